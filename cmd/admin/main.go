@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/micahco/web/internal/models"
+)
+
+const usage = `Usage: admin [flags] <command> [args]
+
+Commands:
+  unlock <email|ip>       clear failed logins and lockout of an account or client IP
+  grant <email> <role>    grant role (admin, moderator) to user
+  revoke <email> <role>   revoke role from user
+
+Flags:
+`
+
+func main() {
+	var dsn string
+
+	flag.StringVar(&dsn, "db-dsn", "", "PostgreSQL DSN")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(dsn, flag.Arg(0), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dsn, cmd string, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	m := models.New(pool)
+
+	switch cmd {
+	case "unlock":
+		if len(args) != 1 {
+			return errors.New("unlock requires an email or ip argument")
+		}
+
+		return unlock(m, args[0])
+	case "grant", "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("%s requires an email and role argument", cmd)
+		}
+
+		return setRole(m, cmd == "grant", args[0], models.Role(args[1]))
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func unlock(m models.Models, target string) error {
+	key := models.LoginAttemptEmailKey(target)
+	if net.ParseIP(target) != nil {
+		key = models.LoginAttemptIPKey(target)
+	}
+
+	err := m.LoginAttempt.Reset(context.Background(), key)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			fmt.Printf("no failed logins for %s\n", target)
+
+			return nil
+		}
+
+		return err
+	}
+
+	fmt.Printf("unlocked %s\n", target)
+
+	return nil
+}
+
+func setRole(m models.Models, grant bool, email string, role models.Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	user, err := m.User.GetWithEmail(context.Background(), email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("no user with email %s", email)
+		}
+
+		return err
+	}
+
+	if grant {
+		err = m.Role.Grant(context.Background(), user.ID, role)
+	} else {
+		err = m.Role.Revoke(context.Background(), user.ID, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	if grant {
+		fmt.Printf("granted %s to %s\n", role, email)
+	} else {
+		fmt.Printf("revoked %s from %s\n", role, email)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+const adminPageSize = 50
+
+func (app *application) handleAdminUsersGet(w http.ResponseWriter, r *http.Request) error {
+	email := r.URL.Query().Get("email")
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// Get one extra user to know if there is a next page
+	users, err := app.models.User.GetAll(r.Context(), email, adminPageSize+1, (page-1)*adminPageSize)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Users    []*models.User
+		Email    string
+		Page     int
+		NextPage int
+	}
+	data.Email = email
+	data.Page = page
+	if len(users) > adminPageSize {
+		users = users[:adminPageSize]
+		data.NextPage = page + 1
+	}
+	data.Users = users
+
+	return app.render(w, r, http.StatusOK, "admin-users.tmpl", data)
+}
+
+// Get user from the id URL parameter. Renders not found and returns nil
+// user if there is no such user.
+func (app *application) adminUser(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (app *application) handleAdminUserGet(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.adminUser(w, r)
+	if user == nil {
+		return err
+	}
+
+	roles, err := app.models.Role.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := app.models.Session.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	creds, err := app.models.Credential.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		User       *models.User
+		Roles      models.Roles
+		Sessions   []*models.Session
+		Passkeys   int
+		CanDisable bool
+	}
+	data.User = user
+	data.Roles = roles
+	data.Sessions = sessions
+	data.Passkeys = len(creds)
+	data.CanDisable = app.roles(r).Can(models.PermissionDisableUsers)
+
+	return app.render(w, r, http.StatusOK, "admin-user.tmpl", data)
+}
+
+func (app *application) handleAdminUserDisablePost(w http.ResponseWriter, r *http.Request) error {
+	return app.setUserDisabled(w, r, true)
+}
+
+func (app *application) handleAdminUserEnablePost(w http.ResponseWriter, r *http.Request) error {
+	return app.setUserDisabled(w, r, false)
+}
+
+func (app *application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) error {
+	user, err := app.adminUser(w, r)
+	if user == nil {
+		return err
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if user.ID == suid {
+		return app.renderError(w, r, http.StatusBadRequest, "cannot disable own account")
+	}
+
+	err = app.models.User.SetDisabled(r.Context(), user.ID, disabled)
+	if err != nil {
+		return err
+	}
+
+	typ, msg := models.AuditAccountEnabled, "Enabled account."
+	if disabled {
+		// Sign the user out everywhere
+		err = app.models.Session.DeleteAllForUser(r.Context(), user.ID, "")
+		if err != nil {
+			return err
+		}
+
+		typ, msg = models.AuditAccountDisabled, "Disabled account."
+	}
+
+	err = app.audit(r, typ, models.AuditSuccess, user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: msg,
+	})
+	http.Redirect(w, r, "/admin/users/"+user.ID.String(), http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) handleAdminUserUnlockPost(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.adminUser(w, r)
+	if user == nil {
+		return err
+	}
+
+	err = app.models.LoginAttempt.Reset(r.Context(), models.LoginAttemptEmailKey(user.Email))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Cleared failed logins.",
+	})
+	http.Redirect(w, r, "/admin/users/"+user.ID.String(), http.StatusSeeOther)
+
+	return nil
+}
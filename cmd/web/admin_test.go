@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/micahco/web/internal/models"
+)
+
+// Create a user with roles and log them in to a new test server.
+func newRoleServer(t *testing.T, app *application, email string, roles ...models.Role) (*testServer, *models.User) {
+	t.Helper()
+
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), email, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, role := range roles {
+		err = app.models.Role.Grant(context.Background(), user.ID, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := login(t, ts, email, testPassword)
+	assertRedirect(t, res, "/")
+
+	return ts, user
+}
+
+func TestAdminPermissions(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	target, err := app.models.User.New(context.Background(), "target@example.com", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := map[string]*testServer{
+		"anonymous": newTestServer(t, app.routes()),
+	}
+	servers["user"], _ = newRoleServer(t, app, "user@example.com")
+	servers["moderator"], _ = newRoleServer(t, app, "moderator@example.com", models.RoleModerator)
+	servers["admin"], _ = newRoleServer(t, app, "admin@example.com", models.RoleAdmin)
+
+	userPath := "/admin/users/" + target.ID.String()
+
+	tests := []struct {
+		server string
+		method string
+		path   string
+		status int
+	}{
+		{"anonymous", http.MethodGet, "/admin/", http.StatusSeeOther},
+		{"user", http.MethodGet, "/admin/", http.StatusForbidden},
+		{"user", http.MethodGet, userPath, http.StatusForbidden},
+		{"user", http.MethodGet, "/admin/audit", http.StatusForbidden},
+		{"user", http.MethodPost, userPath + "/disable", http.StatusForbidden},
+		{"user", http.MethodGet, "/admin/mail", http.StatusForbidden},
+		{"moderator", http.MethodGet, "/admin/", http.StatusOK},
+		{"moderator", http.MethodGet, userPath, http.StatusOK},
+		{"moderator", http.MethodGet, "/admin/audit", http.StatusOK},
+		{"moderator", http.MethodPost, userPath + "/disable", http.StatusForbidden},
+		{"moderator", http.MethodPost, userPath + "/unlock", http.StatusForbidden},
+		{"moderator", http.MethodGet, "/admin/mail", http.StatusForbidden},
+		{"moderator", http.MethodPost, "/admin/mail/1/retry", http.StatusForbidden},
+		{"admin", http.MethodGet, "/admin/", http.StatusOK},
+		{"admin", http.MethodGet, userPath, http.StatusOK},
+		{"admin", http.MethodPost, userPath + "/disable", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			ts := servers[tt.server]
+
+			var res testResponse
+			if tt.method == http.MethodPost {
+				res = ts.postForm(t, tt.path, nil)
+			} else {
+				res = ts.get(t, tt.path)
+			}
+
+			assertStatus(t, res, tt.status)
+		})
+	}
+
+	user, err := app.models.User.GetWithID(context.Background(), target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsDisabled() {
+		t.Fatal("target not disabled by admin")
+	}
+}
+
+func TestAdminRoleAssignment(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts, user := newRoleServer(t, app, testEmail)
+
+	res := ts.get(t, "/admin/")
+	assertStatus(t, res, http.StatusForbidden)
+
+	// Roles are loaded on each request, so a grant applies to the
+	// current session.
+	err := app.models.Role.Grant(context.Background(), user.ID, models.RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = ts.get(t, "/admin/")
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.postForm(t, "/admin/users/"+user.ID.String()+"/unlock", nil)
+	assertStatus(t, res, http.StatusForbidden)
+
+	err = app.models.Role.Grant(context.Background(), user.ID, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := app.models.Role.GetAllForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !roles.Has(models.RoleAdmin) || !roles.Has(models.RoleModerator) {
+		t.Fatalf("got roles %v; want admin and moderator", roles)
+	}
+
+	err = app.models.Role.Revoke(context.Background(), user.ID, models.RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = ts.get(t, "/admin/users/"+user.ID.String())
+	assertStatus(t, res, http.StatusOK)
+
+	err = app.models.Role.Revoke(context.Background(), user.ID, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = ts.get(t, "/admin/")
+	assertStatus(t, res, http.StatusForbidden)
+}
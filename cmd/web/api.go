@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+// Error with a message that is safe to show to API clients
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.message
+}
+
+func newAPIError(status int) apiError {
+	return apiError{status, strings.ToLower(http.StatusText(status))}
+}
+
+// JSON equivalent of handle
+func (app *application) handleAPI(h withError) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			app.writeAPIError(w, r, err)
+		}
+	}
+}
+
+func (app *application) writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr apiError
+	var formErrors FormErrors
+	switch {
+	case errors.As(err, &apiErr):
+		writeJSON(w, apiErr.status, map[string]string{"error": apiErr.message})
+	case errors.As(err, &formErrors):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid request",
+			"fields": formErrors,
+		})
+	default:
+		app.requestLogger(r).ErrorContext(r.Context(), "handled unexpected api error", slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
+
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal server error"})
+	}
+}
+
+// Authenticates requests with a personal access token in the
+// Authorization header. Every request must be authenticated.
+func (app *application) authenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.writeAPIError(w, r, newAPIError(http.StatusUnauthorized))
+
+			return
+		}
+
+		t, err := app.models.APIToken.Authenticate(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				err = newAPIError(http.StatusUnauthorized)
+			}
+			app.writeAPIError(w, r, err)
+
+			return
+		}
+
+		user, err := app.models.User.GetWithID(r.Context(), t.UserID)
+		if err != nil {
+			app.writeAPIError(w, r, err)
+
+			return
+		}
+
+		if user.IsDisabled() {
+			app.writeAPIError(w, r, apiError{http.StatusForbidden, "account disabled"})
+
+			return
+		}
+
+		setRequestUser(r, user.ID)
+
+		ctx := context.WithValue(r.Context(), apiTokenContextKey, t)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Require the API token to have scope.
+func (app *application) requireScope(scope models.APITokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := app.apiToken(r)
+			if !ok {
+				app.writeAPIError(w, r, newAPIError(http.StatusUnauthorized))
+
+				return
+			}
+
+			if !t.Scope.Allows(scope) {
+				app.writeAPIError(w, r, apiError{http.StatusForbidden, "token requires " + string(scope) + " scope"})
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Get the token that authenticated the request. Only routes behind
+// authenticateAPI have one.
+func (app *application) apiToken(r *http.Request) (*models.APIToken, bool) {
+	t, ok := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+
+	return t, ok
+}
+
+func (app *application) apiRoutes(r chi.Router) {
+	r.Use(app.authenticateAPI)
+
+	r.NotFound(app.handleAPI(func(w http.ResponseWriter, r *http.Request) error {
+		return newAPIError(http.StatusNotFound)
+	}))
+	r.MethodNotAllowed(app.handleAPI(func(w http.ResponseWriter, r *http.Request) error {
+		return newAPIError(http.StatusMethodNotAllowed)
+	}))
+
+	r.Group(func(r chi.Router) {
+		r.Use(app.requireScope(models.ScopeRead))
+
+		r.Get("/me", app.handleAPI(app.handleAPIMeGet))
+		r.Get("/sessions", app.handleAPI(app.handleAPISessionsGet))
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(app.requireScope(models.ScopeWrite))
+
+		r.Delete("/sessions/{id}", app.handleAPI(app.handleAPISessionDelete))
+	})
+}
+
+func (app *application) handleAPIMeGet(w http.ResponseWriter, r *http.Request) error {
+	t, ok := app.apiToken(r)
+	if !ok {
+		return newAPIError(http.StatusUnauthorized)
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), t.UserID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]any{
+		"id":         user.ID,
+		"email":      user.Email,
+		"created_at": user.CreatedAt,
+	})
+}
+
+type apiSession struct {
+	ID         uuid.UUID `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+func (app *application) handleAPISessionsGet(w http.ResponseWriter, r *http.Request) error {
+	t, ok := app.apiToken(r)
+	if !ok {
+		return newAPIError(http.StatusUnauthorized)
+	}
+
+	sessions, err := app.models.Session.GetAllForUser(r.Context(), t.UserID)
+	if err != nil {
+		return err
+	}
+
+	data := make([]apiSession, len(sessions))
+	for i, s := range sessions {
+		data[i] = apiSession{s.ID, s.IP, s.UserAgent, s.CreatedAt, s.LastSeenAt}
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]any{"sessions": data})
+}
+
+func (app *application) handleAPISessionDelete(w http.ResponseWriter, r *http.Request) error {
+	t, ok := app.apiToken(r)
+	if !ok {
+		return newAPIError(http.StatusUnauthorized)
+	}
+
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return newAPIError(http.StatusNotFound)
+	}
+
+	err = app.models.Session.Delete(r.Context(), t.UserID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return newAPIError(http.StatusNotFound)
+		}
+
+		return err
+	}
+
+	err = app.audit(r, models.AuditSessionRevoked, models.AuditSuccess, t.UserID, "")
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// Token expiry options in days. Zero never expires.
+var apiTokenExpiryDays = []int{30, 90, 365, 0}
+
+func (app *application) handleAccountTokensPost(w http.ResponseWriter, r *http.Request) error {
+	var form struct {
+		Name       string `form:"name" validate:"required,max=64"`
+		Scope      string `form:"scope" validate:"required,oneof=read write"`
+		ExpiryDays int    `form:"expiry" validate:"oneof=0 30 90 365"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var expiry *time.Time
+	if form.ExpiryDays > 0 {
+		t := time.Now().AddDate(0, 0, form.ExpiryDays)
+		expiry = &t
+	}
+
+	token, t, err := app.models.APIToken.New(r.Context(), suid, form.Name, models.APITokenScope(form.Scope), expiry)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Token    string
+		APIToken *models.APIToken
+	}
+	data.Token = token
+	data.APIToken = t
+
+	// Token is only ever shown on this page
+	w.Header().Add("Cache-Control", "no-store")
+
+	return app.render(w, r, http.StatusOK, "account-token.tmpl", data)
+}
+
+func (app *application) handleAccountTokenRevokePost(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.APIToken.Delete(r.Context(), suid, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "API token revoked.",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+// Make API request with the Authorization header, if not empty.
+func (ts *testServer) api(t *testing.T, method, path, authorization string) testResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+"/api/v1"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return ts.do(t, req)
+}
+
+func assertAPIError(t *testing.T, res testResponse, status int, message string) {
+	t.Helper()
+
+	assertStatus(t, res, status)
+
+	var data struct {
+		Error string `json:"error"`
+	}
+
+	err := json.Unmarshal([]byte(res.body), &data)
+	if err != nil {
+		t.Fatalf("decode error body %q: %v", res.body, err)
+	}
+
+	if data.Error != message {
+		t.Fatalf("got error %q; want %q", data.Error, message)
+	}
+}
+
+func newAPIToken(t *testing.T, app *application, user *models.User, scope models.APITokenScope, expiry *time.Time) string {
+	t.Helper()
+
+	token, _, err := app.models.APIToken.New(context.Background(), user.ID, "test", scope, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	expiredToken := newAPIToken(t, app, user, models.ScopeRead, &expired)
+
+	revokedToken, revoked, err := app.models.APIToken.New(context.Background(), user.ID, "revoked", models.ScopeRead, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.APIToken.Delete(context.Background(), user.ID, revoked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		challenge     string
+	}{
+		{"missing", "", "Bearer"},
+		{"other scheme", "Basic " + newAPIToken(t, app, user, models.ScopeRead, nil), "Bearer"},
+		{"empty token", "Bearer ", "Bearer"},
+		{"unknown token", "Bearer web_UNKNOWN", `Bearer error="invalid_token"`},
+		{"expired token", "Bearer " + expiredToken, `Bearer error="invalid_token"`},
+		{"revoked token", "Bearer " + revokedToken, `Bearer error="invalid_token"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.api(t, http.MethodGet, "/me", tt.authorization)
+			assertAPIError(t, res, http.StatusUnauthorized, "unauthorized")
+
+			if got := res.header.Get("WWW-Authenticate"); got != tt.challenge {
+				t.Fatalf("got challenge %q; want %q", got, tt.challenge)
+			}
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		res := ts.api(t, http.MethodGet, "/me", "Bearer "+newAPIToken(t, app, user, models.ScopeRead, nil))
+		assertStatus(t, res, http.StatusOK)
+
+		var me struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		}
+
+		err := json.Unmarshal([]byte(res.body), &me)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if me.ID != user.ID.String() || me.Email != testEmail {
+			t.Fatalf("got %s; want user %s with email %s", res.body, user.ID, testEmail)
+		}
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		res := ts.api(t, http.MethodGet, "/unknown", "Bearer "+newAPIToken(t, app, user, models.ScopeRead, nil))
+		assertAPIError(t, res, http.StatusNotFound, "not found")
+	})
+
+	t.Run("disabled account", func(t *testing.T) {
+		token := newAPIToken(t, app, user, models.ScopeRead, nil)
+
+		err := app.models.User.SetDisabled(context.Background(), user.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := ts.api(t, http.MethodGet, "/me", "Bearer "+token)
+		assertAPIError(t, res, http.StatusForbidden, "account disabled")
+	})
+}
+
+func TestAPIScope(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := "Bearer " + newAPIToken(t, app, user, models.ScopeRead, nil)
+	write := "Bearer " + newAPIToken(t, app, user, models.ScopeWrite, nil)
+
+	res := ts.api(t, http.MethodDelete, "/sessions/"+user.ID.String(), read)
+	assertAPIError(t, res, http.StatusForbidden, "token requires write scope")
+
+	// Write scope includes read
+	res = ts.api(t, http.MethodGet, "/sessions", write)
+	assertStatus(t, res, http.StatusOK)
+}
+
+func TestAPISessions(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	other := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherUser, err := app.models.User.New(context.Background(), "bob@example.com", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	res = login(t, other, otherUser.Email, testPassword)
+	assertRedirect(t, res, "/")
+
+	token := "Bearer " + newAPIToken(t, app, user, models.ScopeWrite, nil)
+
+	res = ts.api(t, http.MethodGet, "/sessions", token)
+	assertStatus(t, res, http.StatusOK)
+
+	var data struct {
+		Sessions []apiSession `json:"sessions"`
+	}
+
+	err = json.Unmarshal([]byte(res.body), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Sessions) != 1 {
+		t.Fatalf("got %d sessions; want 1", len(data.Sessions))
+	}
+
+	// Sessions of other users can't be revoked
+	otherSessions, err := app.models.Session.GetAllForUser(context.Background(), otherUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = ts.api(t, http.MethodDelete, "/sessions/"+otherSessions[0].ID.String(), token)
+	assertAPIError(t, res, http.StatusNotFound, "not found")
+	assertAuthenticated(t, other, true)
+
+	res = ts.api(t, http.MethodDelete, "/sessions/not-a-uuid", token)
+	assertAPIError(t, res, http.StatusNotFound, "not found")
+
+	res = ts.api(t, http.MethodDelete, "/sessions/"+data.Sessions[0].ID.String(), token)
+	assertStatus(t, res, http.StatusNoContent)
+	assertAuthenticated(t, ts, false)
+}
+
+func TestAPIWithoutToken(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	// Routed without authenticateAPI by mistake
+	rec := httptest.NewRecorder()
+	app.handleAPI(app.handleAPIMeGet)(rec, httptest.NewRequest(http.MethodGet, "/me", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d; want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
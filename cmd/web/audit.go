@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+// Record security event of the request. Use uuid.Nil for events
+// without a known user.
+func (app *application) audit(r *http.Request, typ models.AuditEventType, outcome models.AuditOutcome, userID uuid.UUID, email string) error {
+	e := &models.AuditEvent{
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Email:     email,
+		Type:      typ,
+		Outcome:   outcome,
+		IP:        clientIP(r),
+		UserAgent: userAgent(r),
+	}
+
+	return app.models.AuditEvent.Insert(r.Context(), e)
+}
+
+// Record failed login with email, attributed to the user with that
+// email if there is one.
+func (app *application) auditLoginFailure(r *http.Request, outcome models.AuditOutcome, email string) error {
+	var userID uuid.UUID
+	user, err := app.models.User.GetWithEmail(r.Context(), email)
+	if err == nil {
+		userID = user.ID
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	return app.audit(r, models.AuditLogin, outcome, userID, email)
+}
+
+func (app *application) handleAdminAuditGet(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var filter models.AuditFilter
+	filter.Type = models.AuditEventType(q.Get("type"))
+	if id, err := uuid.FromString(q.Get("user")); err == nil {
+		filter.UserID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	// Match events of the user with email, or failed logins of an
+	// unknown email.
+	email := q.Get("email")
+	if email != "" {
+		user, err := app.models.User.GetWithEmail(r.Context(), email)
+		switch {
+		case err == nil:
+			filter.UserID = uuid.NullUUID{UUID: user.ID, Valid: true}
+		case errors.Is(err, models.ErrNoRecord):
+			filter.Email = email
+		default:
+			return err
+		}
+	}
+
+	// Get one extra event to know if there is a next page
+	events, err := app.models.AuditEvent.GetAll(r.Context(), filter, adminPageSize+1, (page-1)*adminPageSize)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Events   []*models.AuditEvent
+		Types    []models.AuditEventType
+		User     string
+		Email    string
+		Type     string
+		Page     int
+		NextPage int
+	}
+	data.Types = models.AuditEventTypes
+	data.User = q.Get("user")
+	data.Email = email
+	data.Type = string(filter.Type)
+	data.Page = page
+	if len(events) > adminPageSize {
+		events = events[:adminPageSize]
+		data.NextPage = page + 1
+	}
+	data.Events = events
+
+	return app.render(w, r, http.StatusOK, "admin-audit.tmpl", data)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -15,12 +16,22 @@ import (
 type contextKey string
 
 const (
-	authenticatedUserIDSessionKey = "authenticatedUserID"
-	verificationEmailSessionKey   = "verificationEmail"
-	verificationTokenSessionKey   = "verificationToken"
-	resetEmailSessionKey          = "resetEmail"
-	resetTokenSessionKey          = "resetToken"
-	isAuthenticatedContextKey     = contextKey("isAuthenticated")
+	authenticatedUserIDSessionKey  = "authenticatedUserID"
+	verificationEmailSessionKey    = "verificationEmail"
+	verificationTokenSessionKey    = "verificationToken"
+	resetEmailSessionKey           = "resetEmail"
+	resetTokenSessionKey           = "resetToken"
+	twoFactorUserIDSessionKey      = "twoFactorUserID"
+	twoFactorSecretSessionKey      = "twoFactorSecret"
+	webauthnRegistrationSessionKey = "webauthnRegistration"
+	webauthnLoginSessionKey        = "webauthnLogin"
+	oidcStateSessionKey            = "oidcState"
+	oidcNonceSessionKey            = "oidcNonce"
+	oidcVerifierSessionKey         = "oidcVerifier"
+	isAuthenticatedContextKey      = contextKey("isAuthenticated")
+	rolesContextKey                = contextKey("roles")
+	apiTokenContextKey             = contextKey("apiToken")
+	requestInfoContextKey          = contextKey("requestInfo")
 )
 
 func (app *application) login(r *http.Request, userID uuid.UUID) error {
@@ -31,11 +42,49 @@ func (app *application) login(r *http.Request, userID uuid.UUID) error {
 
 	app.sessionManager.Put(r.Context(), authenticatedUserIDSessionKey, userID)
 
-	return nil
+	// Logging in cancels scheduled deletion of the account
+	cancelled, err := app.models.User.CancelDeletion(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	if cancelled {
+		app.putFlash(r, FlashMessage{
+			Type:    FlashInfo,
+			Message: "Welcome back! Your account is no longer scheduled for deletion.",
+		})
+	}
+
+	// Index the new session, so the user can see and revoke it
+	session := &models.Session{
+		Token:     app.sessionManager.Token(r.Context()),
+		UserID:    userID,
+		IP:        clientIP(r),
+		UserAgent: userAgent(r),
+	}
+
+	err = app.models.Session.Insert(r.Context(), session)
+	if err != nil {
+		return err
+	}
+
+	return app.audit(r, models.AuditLogin, models.AuditSuccess, userID, "")
 }
 
 func (app *application) logout(r *http.Request) error {
-	err := app.sessionManager.RenewToken(r.Context())
+	err := app.models.Session.DeleteWithToken(r.Context(), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return err
+	}
+
+	if suid, err := app.getSessionUserID(r); err == nil {
+		err = app.audit(r, models.AuditLogout, models.AuditSuccess, suid, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		return err
 	}
@@ -55,6 +104,13 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
+// Get roles of the authenticated user set by the authenticate middleware
+func (app *application) roles(r *http.Request) models.Roles {
+	roles, _ := r.Context().Value(rolesContextKey).(models.Roles)
+
+	return roles
+}
+
 func (app *application) getSessionUserID(r *http.Request) (uuid.UUID, error) {
 	id, ok := app.sessionManager.Get(r.Context(), authenticatedUserIDSessionKey).(uuid.UUID)
 	if !ok {
@@ -63,6 +119,70 @@ func (app *application) getSessionUserID(r *http.Request) (uuid.UUID, error) {
 
 	return id, nil
 }
+
+// Get IP address of the client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Get user agent of the client, truncated for storage
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > 512 {
+		ua = ua[:512]
+	}
+
+	return ua
+}
+
+// Create absolute link to path with token query
+func (app *application) tokenLink(path, token string) *url.URL {
+	ref := &url.URL{Path: path}
+	q := ref.Query()
+	q.Set("token", token)
+	ref.RawQuery = q.Encode()
+
+	return app.baseURL.ResolveReference(ref)
+}
+
+// Page that confirms the action of a link sent by email
+type tokenConfirmData struct {
+	Title   string
+	Message string
+	Action  string
+	Button  string
+	Token   string
+}
+
+// Render confirmation page for the token in the query. Following a link
+// only renders the page, and the token is consumed when its form is
+// posted, so mail scanners and link prefetchers can't use it up.
+func (app *application) renderTokenConfirm(w http.ResponseWriter, r *http.Request, data tokenConfirmData) error {
+	data.Token = r.URL.Query().Get("token")
+	if data.Token == "" {
+		return app.renderError(w, r, http.StatusBadRequest, "missing verification token")
+	}
+
+	return app.render(w, r, http.StatusOK, "token-confirm.tmpl", data)
+}
+
+// Data of mail templates with a link
+type linkMailData struct {
+	Link *url.URL
+}
+
+// Queue mail with link to be sent by the mail workers
+func (app *application) sendMail(r *http.Request, recipient, tmpl string, link *url.URL) error {
+	app.requestLogger(r).DebugContext(r.Context(), "mailed", slog.String("link", link.String()))
+
+	return app.mailer.Send(r.Context(), recipient, tmpl, linkMailData{link})
+}
+
 func (app *application) handleAuthLoginPost(w http.ResponseWriter, r *http.Request) error {
 	if app.isAuthenticated(r) {
 		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
@@ -78,25 +198,63 @@ func (app *application) handleAuthLoginPost(w http.ResponseWriter, r *http.Reque
 		return err
 	}
 
-	user, err := app.models.User.GetForCredentials(form.Email, form.Password)
+	emailKey, ipKey := loginAttemptKeys(r, form.Email)
+
+	// Reject attempts while the account or client is locked out
+	err = app.models.LoginAttempt.Check(r.Context(), emailKey, ipKey)
 	if err != nil {
 		switch {
-		case errors.Is(err, models.ErrInvalidCredentials):
-			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		case errors.Is(err, models.ErrLockedOut):
+			err = app.auditLoginFailure(r, models.AuditLockedOut, form.Email)
+			if err != nil {
+				return err
+			}
+
+			return app.renderError(w, r, http.StatusTooManyRequests, "Too many failed login attempts. Please try again later.")
 		default:
 			return err
 		}
 	}
 
-	err = app.login(r, user.ID)
+	user, err := app.models.User.GetForCredentials(r.Context(), form.Email, form.Password)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			err = app.recordLoginFailure(r, form.Email, emailKey, ipKey)
+			if err != nil {
+				return err
+			}
+
+			err = app.auditLoginFailure(r, models.AuditFailure, form.Email)
+			if err != nil {
+				return err
+			}
+
+			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		case errors.Is(err, models.ErrDisabled):
+			err = app.auditLoginFailure(r, models.AuditDisabled, form.Email)
+			if err != nil {
+				return err
+			}
+
+			return app.renderError(w, r, http.StatusForbidden, accountDisabledMessage)
+		default:
+			return err
+		}
 	}
 
-	// Redirect to homepage after authenticating the user.
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// A successful login clears failures of the account and the client,
+	// so failures of users behind a shared address don't add up
+	for _, key := range []string{emailKey, ipKey} {
+		err = app.models.LoginAttempt.Reset(r.Context(), key)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+	}
 
-	return nil
+	// Redirects to homepage after authenticating the user, or to the
+	// second step if they have two-factor authentication enabled.
+	return app.loginWithSecondFactor(w, r, user)
 }
 
 func (app *application) handleAuthLogoutPost(w http.ResponseWriter, r *http.Request) error {
@@ -131,7 +289,7 @@ func (app *application) handleAuthSignupPost(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Check if user with email already exists
-	exists, err := app.models.User.ExistsWithEmail(form.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), form.Email)
 	if err != nil {
 		return err
 	}
@@ -145,7 +303,7 @@ func (app *application) handleAuthSignupPost(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Check if link verification has already been created
-	v, err := app.models.Verification.Get(form.Email)
+	v, err := app.models.Verification.Get(r.Context(), form.Email, models.PurposeSignup)
 	if err != nil && err != models.ErrNoRecord {
 		return err
 	}
@@ -160,31 +318,16 @@ func (app *application) handleAuthSignupPost(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	token, err := app.models.Verification.New(form.Email)
+	token, err := app.models.Verification.New(r.Context(), form.Email, models.PurposeSignup)
 	if err != nil {
 		return fmt.Errorf("signup create token: %w", err)
 	}
 
-	// Create link with token
-	ref, err := url.Parse("/auth/register")
+	link := app.tokenLink("/auth/register", token)
+	err = app.sendMail(r, form.Email, "email-verification.tmpl", link)
 	if err != nil {
 		return err
 	}
-	q := ref.Query()
-	q.Set("token", token)
-	ref.RawQuery = q.Encode()
-	link := app.baseURL.ResolveReference(ref)
-
-	// Send mail in background routine
-	if !app.config.dev {
-		app.background(func() {
-			err = app.mailer.Send(form.Email, "email-verification.tmpl", link)
-			if err != nil {
-				app.logger.Error("mailer", slog.Any("err", err))
-			}
-		})
-	}
-	app.logger.Debug("mailed", slog.String("link", link.String()))
 
 	// Clear all session data and add form email to session. That way,
 	// when the user goes to register, won't have to re-enter email.
@@ -220,6 +363,8 @@ func (app *application) handleAuthRegisterGet(w http.ResponseWriter, r *http.Req
 	return app.render(w, r, http.StatusOK, "auth-register.tmpl", data)
 }
 
+const accountDisabledMessage = "This account has been disabled."
+
 var ExpiredTokenFlash = FlashMessage{
 	Type:    FlashError,
 	Message: "Expired verification token.",
@@ -230,9 +375,10 @@ func (app *application) handleAuthRegisterPost(w http.ResponseWriter, r *http.Re
 		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
 	}
 
+	// Password is optional, accounts without one login with email links.
 	var form struct {
 		Email    string `form:"email" validate:"required,email,max=254"`
-		Password string `form:"password" validate:"required,min=8,max=72"`
+		Password string `form:"password" validate:"omitempty,min=8,max=72"`
 	}
 
 	form.Email = app.sessionManager.GetString(r.Context(), verificationEmailSessionKey)
@@ -246,36 +392,27 @@ func (app *application) handleAuthRegisterPost(w http.ResponseWriter, r *http.Re
 		return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
 	}
 
-	err = app.models.Verification.Verify(token, form.Email)
+	// The token is only consumed if the user is created
+	user, err := app.models.User.Register(r.Context(), token, form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
+		switch {
+		case errors.Is(err, models.ErrNoRecord), errors.Is(err, models.ErrDuplicateEmail):
 			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
-		}
-		if errors.Is(err, models.ErrExpiredVerification) {
+		case errors.Is(err, models.ErrExpiredVerification):
 			app.putFlash(r, ExpiredTokenFlash)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 
 			return nil
+		default:
+			return err
 		}
-
-		return err
 	}
 
-	// Upon registration, purge db of all verifications with email.
-	err = app.models.Verification.Purge(form.Email)
+	err = app.audit(r, models.AuditSignup, models.AuditSuccess, user.ID, user.Email)
 	if err != nil {
 		return err
 	}
 
-	user, err := app.models.User.New(form.Email, form.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
-		}
-
-		return err
-	}
-
 	// Login user
 	app.sessionManager.Clear(r.Context())
 	err = app.login(r, user.ID)
@@ -307,7 +444,7 @@ func (app *application) handleAuthResetPost(w http.ResponseWriter, r *http.Reque
 			return err
 		}
 
-		user, err := app.models.User.GetWithID(suid)
+		user, err := app.models.User.GetWithID(r.Context(), suid)
 		if err != nil {
 			return err
 		}
@@ -327,7 +464,7 @@ func (app *application) handleAuthResetPost(w http.ResponseWriter, r *http.Reque
 		email = form.Email
 	}
 
-	exists, err := app.models.User.ExistsWithEmail(email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), email)
 	if err != nil {
 		return err
 	}
@@ -346,7 +483,7 @@ func (app *application) handleAuthResetPost(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Check if link verification has already been created
-	v, err := app.models.Verification.Get(email)
+	v, err := app.models.Verification.Get(r.Context(), email, models.PurposeReset)
 	if err != nil && err != models.ErrNoRecord {
 		return err
 	}
@@ -361,31 +498,16 @@ func (app *application) handleAuthResetPost(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	token, err := app.models.Verification.New(email)
+	token, err := app.models.Verification.New(r.Context(), email, models.PurposeReset)
 	if err != nil {
 		return err
 	}
 
-	// Create link with token
-	ref, err := url.Parse("/auth/reset/update")
+	link := app.tokenLink("/auth/reset/update", token)
+	err = app.sendMail(r, email, "reset-password.tmpl", link)
 	if err != nil {
 		return err
 	}
-	q := ref.Query()
-	q.Set("token", token)
-	ref.RawQuery = q.Encode()
-	link := app.baseURL.ResolveReference(ref)
-
-	// Send mail in background routine
-	if !app.config.dev {
-		app.background(func() {
-			err = app.mailer.Send(email, "email-verification.tmpl", link)
-			if err != nil {
-				app.logger.Error("mailer", slog.Any("err", err))
-			}
-		})
-	}
-	app.logger.Debug("mailed", slog.String("link", link.String()))
 
 	app.sessionManager.RenewToken(r.Context())
 	app.sessionManager.Put(r.Context(), resetEmailSessionKey, email)
@@ -429,7 +551,7 @@ func (app *application) handleAuthResetUpdatePost(w http.ResponseWriter, r *http
 		return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
 	}
 
-	err = app.models.Verification.Verify(token, form.Email)
+	err = app.models.Verification.Verify(r.Context(), token, form.Email, models.PurposeReset)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
@@ -444,7 +566,7 @@ func (app *application) handleAuthResetUpdatePost(w http.ResponseWriter, r *http
 		return err
 	}
 
-	user, err := app.models.User.GetWithEmail(form.Email)
+	user, err := app.models.User.GetWithEmail(r.Context(), form.Email)
 	if err != nil {
 		return err
 	}
@@ -454,12 +576,23 @@ func (app *application) handleAuthResetUpdatePost(w http.ResponseWriter, r *http
 		return err
 	}
 
-	err = app.models.User.Update(user)
+	err = app.models.User.Update(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Verification.Purge(r.Context(), form.Email, models.PurposeReset)
+	if err != nil {
+		return err
+	}
+
+	err = app.audit(r, models.AuditPasswordReset, models.AuditSuccess, user.ID, user.Email)
 	if err != nil {
 		return err
 	}
 
-	err = app.models.Verification.Purge(form.Email)
+	// Sign out everywhere else, in case the old password was compromised
+	err = app.models.Session.DeleteAllForUser(r.Context(), user.ID, app.sessionManager.Token(r.Context()))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/micahco/web/internal/models"
+)
+
+const (
+	testEmail       = "alice@example.com"
+	testPassword    = "correct horse"
+	testNewPassword = "battery staple"
+)
+
+func login(t *testing.T, ts *testServer, email, password string) testResponse {
+	t.Helper()
+
+	return ts.postForm(t, "/auth/login", url.Values{
+		"email":    {email},
+		"password": {password},
+	})
+}
+
+func assertAuthenticated(t *testing.T, ts *testServer, want bool) {
+	t.Helper()
+
+	res := ts.get(t, "/account/sessions")
+	if want {
+		assertStatus(t, res, http.StatusOK)
+	} else {
+		assertRedirect(t, res, "/auth/login")
+	}
+}
+
+func TestAuthFlow(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	t.Run("signup", func(t *testing.T) {
+		res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+		assertRedirect(t, res, ts.URL+"/")
+
+		link := mailLink(t, mailbox, testEmail)
+		if !strings.HasPrefix(link, "/auth/register?token=") {
+			t.Fatalf("got link %q; want register link", link)
+		}
+
+		res = ts.get(t, link)
+		assertStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("register", func(t *testing.T) {
+		res := ts.postForm(t, "/auth/register", url.Values{"password": {testPassword}})
+		assertRedirect(t, res, "/")
+
+		assertAuthenticated(t, ts, true)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		res := ts.postForm(t, "/auth/logout", nil)
+		assertRedirect(t, res, "/")
+
+		assertAuthenticated(t, ts, false)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		res := login(t, ts, testEmail, "wrong password")
+		assertStatus(t, res, http.StatusUnauthorized)
+		assertAuthenticated(t, ts, false)
+
+		res = login(t, ts, testEmail, testPassword)
+		assertRedirect(t, res, "/")
+		assertAuthenticated(t, ts, true)
+
+		res = ts.postForm(t, "/auth/logout", nil)
+		assertRedirect(t, res, "/")
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		res := ts.postForm(t, "/auth/reset", url.Values{"email": {testEmail}})
+		assertRedirect(t, res, ts.URL+"/")
+
+		link := mailLink(t, mailbox, testEmail)
+		if !strings.HasPrefix(link, "/auth/reset/update?token=") {
+			t.Fatalf("got link %q; want reset link", link)
+		}
+
+		res = ts.get(t, link)
+		assertStatus(t, res, http.StatusOK)
+
+		res = ts.postForm(t, "/auth/reset/update", url.Values{"password": {testNewPassword}})
+		assertRedirect(t, res, "/")
+
+		res = login(t, ts, testEmail, testPassword)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = login(t, ts, testEmail, testNewPassword)
+		assertRedirect(t, res, "/")
+		assertAuthenticated(t, ts, true)
+	})
+}
+
+func TestAuthSignupExistingEmail(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same response as for a new email, but nothing is sent
+	res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	if n := len(mailbox.Messages()); n != 0 {
+		t.Fatalf("got %d mails; want none", n)
+	}
+}
+
+func TestAuthRegisterToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(link string) string
+		want  int
+	}{
+		{"valid", func(link string) string { return link }, http.StatusSeeOther},
+		{"invalid", func(string) string { return "/auth/register?token=INVALID" }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mailbox := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+
+			res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+			assertRedirect(t, res, ts.URL+"/")
+
+			res = ts.get(t, tt.token(mailLink(t, mailbox, testEmail)))
+			assertStatus(t, res, http.StatusOK)
+
+			res = ts.postForm(t, "/auth/register", url.Values{"password": {testPassword}})
+			assertStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestAuthRegisterTokenReuse(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	link := mailLink(t, mailbox, testEmail)
+
+	res = ts.get(t, link)
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.postForm(t, "/auth/register", url.Values{"password": {testPassword}})
+	assertRedirect(t, res, "/")
+
+	res = ts.postForm(t, "/auth/logout", nil)
+	assertRedirect(t, res, "/")
+
+	// The token was consumed by registering
+	res = ts.get(t, link)
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.postForm(t, "/auth/register", url.Values{
+		"email":    {testEmail},
+		"password": {testPassword},
+	})
+	assertStatus(t, res, http.StatusUnauthorized)
+}
+
+func TestAuthRegisterFailureKeepsToken(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	res = ts.get(t, mailLink(t, mailbox, testEmail))
+	assertStatus(t, res, http.StatusOK)
+
+	// Registered some other way in the meantime
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = ts.postForm(t, "/auth/register", url.Values{"password": {testPassword}})
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	_, err = app.models.Verification.Get(context.Background(), testEmail, models.PurposeSignup)
+	if err != nil {
+		t.Fatalf("got %v; want token to be kept", err)
+	}
+}
+
+func TestAuthLoginLockout(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range accountLockoutThreshold {
+		res := login(t, ts, testEmail, "wrong password")
+		assertStatus(t, res, http.StatusUnauthorized)
+	}
+
+	// Locked out even with the right password, and the owner is notified
+	res := login(t, ts, testEmail, testPassword)
+	assertStatus(t, res, http.StatusTooManyRequests)
+
+	if link := mailLink(t, mailbox, testEmail); link != "/auth/reset" {
+		t.Fatalf("got link %q; want /auth/reset", link)
+	}
+}
+
+func TestAuthLoginResetsClientFailures(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failures of other users behind the same address, with successful
+	// logins in between, never lock out the address
+	for i := range 2 {
+		for j := range ipLockoutThreshold - 1 {
+			res := login(t, ts, fmt.Sprintf("user%d-%d@example.com", i, j), testPassword)
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		res := login(t, ts, testEmail, testPassword)
+		assertRedirect(t, res, "/")
+
+		res = ts.postForm(t, "/auth/logout", nil)
+		assertRedirect(t, res, "/")
+	}
+}
+
+func TestAuthResetRevokesOtherSessions(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	other := newTestServer(t, app.routes())
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, other, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+	assertAuthenticated(t, other, true)
+
+	res = ts.postForm(t, "/auth/reset", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	res = ts.get(t, mailLink(t, mailbox, testEmail))
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.postForm(t, "/auth/reset/update", url.Values{"password": {testNewPassword}})
+	assertRedirect(t, res, "/")
+
+	assertAuthenticated(t, other, false)
+}
+
+func TestAuthLoginClientLockout(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// Each attempt comes from a new connection, and so from a new port
+	for i := range ipLockoutThreshold {
+		ts.Client().CloseIdleConnections()
+
+		res := login(t, ts, fmt.Sprintf("user%d@example.com", i), testPassword)
+		assertStatus(t, res, http.StatusUnauthorized)
+	}
+
+	ts.Client().CloseIdleConnections()
+
+	res := login(t, ts, testEmail, testPassword)
+	assertStatus(t, res, http.StatusTooManyRequests)
+
+	// Tracked by address, without the port
+	err := app.models.LoginAttempt.Reset(context.Background(), models.LoginAttemptIPKey("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
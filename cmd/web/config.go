@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	port        int
+	metricsPort int
+	dev         bool
+	url         string
+	db          struct {
+		dsn string
+	}
+	mail struct {
+		transport string
+		dir       string
+		workers   int
+	}
+	dkim struct {
+		domain   string
+		selector string
+		keyFile  string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	oidc struct {
+		name         string
+		issuer       string
+		clientID     string
+		clientSecret string
+	}
+	trace struct {
+		exporter    string
+		endpoint    string
+		file        string
+		sampleRatio float64
+	}
+	deletionGrace   time.Duration
+	shutdownTimeout time.Duration
+	printConfig     bool
+}
+
+// Settings that are redacted when printed, and can be read from the file
+// named by the setting with a -file suffix.
+var secretFlags = []string{"db-dsn", "smtp-pass", "oidc-client-secret"}
+
+// Prefix of environment variables. The variable of a setting is the flag
+// name in upper case with dashes replaced by underscores, e.g. WEB_DB_DSN.
+const envPrefix = "WEB_"
+
+// Load config from, in increasing order of precedence: flag defaults, the
+// config file, WEB_* environment variables and command line flags.
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	var cfg config
+	var configFile string
+
+	fs := flag.NewFlagSet("web", flag.ContinueOnError)
+
+	fs.StringVar(&configFile, "config", "", "Config file (.toml, .yaml or .json)")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print effective config with secrets redacted and exit")
+
+	// Default flag values for production
+	fs.IntVar(&cfg.port, "port", 8080, "API server port")
+	fs.IntVar(&cfg.metricsPort, "metrics-port", 0, "Admin listener port for Prometheus metrics (0 disables)")
+	fs.BoolVar(&cfg.dev, "dev", false, "Development mode")
+	fs.StringVar(&cfg.url, "url", "", "Base URL")
+	fs.DurationVar(&cfg.deletionGrace, "deletion-grace", 14*24*time.Hour, "Grace period before deleting an account")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for requests and background tasks on shutdown")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+
+	fs.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "Mail transport (smtp|file|log)")
+	fs.StringVar(&cfg.mail.dir, "mail-dir", "./tmp/mail", "Directory of the file mail transport")
+	fs.IntVar(&cfg.mail.workers, "mail-workers", 2, "Number of mail queue workers")
+
+	fs.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain")
+	fs.StringVar(&cfg.dkim.selector, "dkim-selector", "", "DKIM selector")
+	fs.StringVar(&cfg.dkim.keyFile, "dkim-key", "", "DKIM private key PEM file (signing disabled if empty)")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-user", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-pass", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-addr", "", "SMTP sender address")
+
+	fs.StringVar(&cfg.oidc.name, "oidc-name", "OpenID", "OIDC provider display name")
+	fs.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OIDC issuer URL (disabled if empty)")
+	fs.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OIDC client ID")
+	fs.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OIDC client secret")
+
+	fs.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Trace exporter (none|otlp|stdout)")
+	fs.StringVar(&cfg.trace.endpoint, "trace-endpoint", "", "OTLP/HTTP endpoint, e.g. localhost:4318 (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.StringVar(&cfg.trace.file, "trace-file", "", "File the stdout trace exporter writes to (stdout if empty)")
+	fs.Float64Var(&cfg.trace.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample")
+
+	secretFiles := make(map[string]*string)
+	for _, name := range secretFlags {
+		secretFiles[name] = fs.String(name+"-file", "", "File to read "+name+" from")
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	// Layer that set each flag. Flags set on the command line take
+	// precedence over everything else.
+	layers := make(map[string]int)
+	fs.Visit(func(f *flag.Flag) {
+		layers[f.Name] = layerFlag
+	})
+
+	err = checkSecretFiles("flags", func(name string) bool {
+		return layers[name] == layerFlag
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	if configFile == "" {
+		configFile = getenv(envPrefix + "CONFIG")
+	}
+
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return cfg, err
+		}
+
+		err = checkSecretFiles("config file "+configFile, func(name string) bool {
+			_, ok := values[name]
+			return ok
+		})
+		if err != nil {
+			return cfg, err
+		}
+
+		for name, value := range values {
+			if layers[name] == layerFlag {
+				continue
+			}
+
+			err = fs.Set(name, value)
+			if err != nil {
+				return cfg, fmt.Errorf("config file %s: %s: %w", configFile, name, err)
+			}
+			layers[name] = layerFile
+		}
+	}
+
+	err = checkSecretFiles("environment", func(name string) bool {
+		return getenv(envKey(name)) != ""
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if layers[f.Name] == layerFlag || f.Name == "config" {
+			return
+		}
+
+		key := envKey(f.Name)
+		if value := getenv(key); value != "" {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+			layers[f.Name] = layerEnv
+		}
+	})
+	if len(errs) > 0 {
+		return cfg, errors.Join(errs...)
+	}
+
+	// A secret file only applies if it was set by a layer with higher
+	// precedence than the secret itself.
+	for name, file := range secretFiles {
+		if *file == "" || layers[name+"-file"] < layers[name] {
+			continue
+		}
+
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return cfg, err
+		}
+
+		err = fs.Set(name, strings.TrimRight(string(b), "\r\n"))
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.printConfig {
+		printConfig(os.Stdout, fs)
+	}
+
+	return cfg, cfg.validate()
+}
+
+// Layers of config, in increasing order of precedence
+const (
+	layerDefault = iota
+	layerFile
+	layerEnv
+	layerFlag
+)
+
+// Reject secrets that are set together with their file in the same
+// layer, since neither would take precedence.
+func checkSecretFiles(layer string, isSet func(name string) bool) error {
+	var errs []error
+	for _, name := range secretFlags {
+		if isSet(name) && isSet(name+"-file") {
+			errs = append(errs, fmt.Errorf("%s: %s and %s-file are both set", layer, name, name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func envKey(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Read config file into flag values. Nested keys are joined with dashes,
+// so {"db": {"dsn": "..."}} sets -db-dsn.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, &data)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &data)
+	case ".json":
+		// Numbers are kept as written, instead of as float64
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&data)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", data, values)
+
+	return values, nil
+}
+
+func flattenConfig(prefix string, data map[string]any, values map[string]string) {
+	for k, v := range data {
+		name := k
+		if prefix != "" {
+			name = prefix + "-" + k
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			flattenConfig(name, v, values)
+		case float64:
+			// Without exponent, which integer flags don't accept
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Print flag values, one per line, with secrets redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if slices.Contains(secretFlags, f.Name) && value != "" {
+			value = "[redacted]"
+		}
+
+		fmt.Fprintf(w, "%s = %s\n", f.Name, value)
+	})
+}
+
+func (cfg config) validate() error {
+	var errs []error
+
+	u, err := url.Parse(cfg.url)
+	switch {
+	case cfg.url == "":
+		errs = append(errs, errors.New("url is required"))
+	case err != nil:
+		errs = append(errs, fmt.Errorf("url: %w", err))
+	case u.Scheme != "http" && u.Scheme != "https" || u.Host == "":
+		errs = append(errs, errors.New("url must be an absolute http(s) URL"))
+	}
+
+	if cfg.port < 1 || cfg.port > 65535 {
+		errs = append(errs, errors.New("port must be between 1 and 65535"))
+	}
+
+	switch {
+	case cfg.metricsPort < 0 || cfg.metricsPort > 65535:
+		errs = append(errs, errors.New("metrics-port must be between 0 and 65535"))
+	case cfg.metricsPort == cfg.port:
+		errs = append(errs, errors.New("metrics-port must differ from port"))
+	}
+
+	if cfg.db.dsn == "" {
+		errs = append(errs, errors.New("db-dsn is required"))
+	} else if _, err := pgxpool.ParseConfig(cfg.db.dsn); err != nil {
+		errs = append(errs, errors.New("db-dsn is invalid"))
+	}
+
+	if _, err := mail.ParseAddress(cfg.smtp.sender); err != nil {
+		errs = append(errs, errors.New("smtp-addr must be a valid email address"))
+	}
+
+	switch cfg.mail.transport {
+	case "smtp":
+		if cfg.smtp.host == "" {
+			errs = append(errs, errors.New("smtp-host is required by the smtp mail transport"))
+		}
+	case "file", "log":
+	default:
+		errs = append(errs, fmt.Errorf("unknown mail-transport %q", cfg.mail.transport))
+	}
+
+	if cfg.mail.workers < 1 {
+		errs = append(errs, errors.New("mail-workers must be at least 1"))
+	}
+
+	if cfg.dkim.keyFile != "" && (cfg.dkim.domain == "" || cfg.dkim.selector == "") {
+		errs = append(errs, errors.New("dkim-domain and dkim-selector are required with dkim-key"))
+	}
+
+	if cfg.oidc.issuer != "" && cfg.oidc.clientID == "" {
+		errs = append(errs, errors.New("oidc-client-id is required with oidc-issuer"))
+	}
+
+	switch cfg.trace.exporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("unknown trace-exporter %q", cfg.trace.exporter))
+	}
+
+	if cfg.trace.sampleRatio < 0 || cfg.trace.sampleRatio > 1 {
+		errs = append(errs, errors.New("trace-sample-ratio must be between 0 and 1"))
+	}
+
+	return errors.Join(errs...)
+}
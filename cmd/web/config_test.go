@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	secret := filepath.Join(dir, "dsn")
+	err := os.WriteFile(secret, []byte("postgres://secret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "config.toml")
+	err = os.WriteFile(file, []byte("port = 8081\n\n[db]\ndsn = \"postgres://file\"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conflict := filepath.Join(dir, "conflict.toml")
+	err = os.WriteFile(conflict, []byte("[db]\ndsn = \"postgres://file\"\ndsn-file = \""+secret+"\"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		port    int
+		dsn     string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			args: []string{"-db-dsn", "postgres://flag"},
+			port: 8080,
+			dsn:  "postgres://flag",
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			port: 8081,
+			dsn:  "postgres://file",
+		},
+		{
+			name: "env over file",
+			args: []string{"-config", file},
+			env:  map[string]string{"WEB_PORT": "8082", "WEB_DB_DSN": "postgres://env"},
+			port: 8082,
+			dsn:  "postgres://env",
+		},
+		{
+			name: "flags over env",
+			args: []string{"-config", file, "-port", "8083", "-db-dsn", "postgres://flag"},
+			env:  map[string]string{"WEB_PORT": "8082", "WEB_DB_DSN": "postgres://env"},
+			port: 8083,
+			dsn:  "postgres://flag",
+		},
+		{
+			name: "env file over file",
+			args: []string{"-config", file},
+			env:  map[string]string{"WEB_DB_DSN_FILE": secret},
+			port: 8081,
+			dsn:  "postgres://secret",
+		},
+		{
+			name: "flag over env file",
+			args: []string{"-db-dsn", "postgres://flag"},
+			env:  map[string]string{"WEB_DB_DSN_FILE": secret},
+			port: 8080,
+			dsn:  "postgres://flag",
+		},
+		{
+			name: "flag file over env",
+			args: []string{"-db-dsn-file", secret},
+			env:  map[string]string{"WEB_DB_DSN": "postgres://env"},
+			port: 8080,
+			dsn:  "postgres://secret",
+		},
+		{
+			name:    "secret and file in flags",
+			args:    []string{"-db-dsn", "postgres://flag", "-db-dsn-file", secret},
+			wantErr: true,
+		},
+		{
+			name:    "secret and file in env",
+			env:     map[string]string{"WEB_DB_DSN": "postgres://env", "WEB_DB_DSN_FILE": secret},
+			wantErr: true,
+		},
+		{
+			name:    "secret and file in config file",
+			args:    []string{"-config", conflict},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{
+				"WEB_URL":            "https://example.com",
+				"WEB_SMTP_ADDR":      "web@example.com",
+				"WEB_MAIL_TRANSPORT": "log",
+			}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+
+			cfg, err := loadConfig(tt.args, func(key string) string {
+				return env[key]
+			})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "are both set") {
+					t.Fatalf("got error %v; want conflicting secret error", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.port != tt.port {
+				t.Errorf("got port %d; want %d", cfg.port, tt.port)
+			}
+			if cfg.db.dsn != tt.dsn {
+				t.Errorf("got db-dsn %q; want %q", cfg.db.dsn, tt.dsn)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileNumbers(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		file    string
+		content string
+		want    string
+	}{
+		{"config.json", `{"mail": {"workers": 1000000}}`, "1000000"},
+		{"large.json", `{"mail": {"workers": 12345678901234567890}}`, "12345678901234567890"},
+		{"config.yaml", "mail:\n  workers: 1.0e+6\n", "1000000"},
+		{"config.toml", "[mail]\nworkers = 1000000\n", "1000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			err := os.WriteFile(path, []byte(tt.content), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values, err := readConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := values["mail-workers"]; got != tt.want {
+				t.Fatalf("got mail-workers %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Large integers set integer flags
+	cfg, err := loadConfig([]string{"-config", filepath.Join(dir, "config.json")}, func(key string) string {
+		return map[string]string{
+			"WEB_URL":            "https://example.com",
+			"WEB_SMTP_ADDR":      "web@example.com",
+			"WEB_MAIL_TRANSPORT": "log",
+			"WEB_DB_DSN":         "postgres://env",
+		}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.mail.workers != 1000000 {
+		t.Fatalf("got mail-workers %d; want 1000000", cfg.mail.workers)
+	}
+}
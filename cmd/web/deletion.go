@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+func (app *application) handleAccountDeleteGet(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		HasPassword bool
+		GraceDays   int
+	}
+	data.HasPassword = user.HasPassword()
+	data.GraceDays = int(app.config.deletionGrace.Hours() / 24)
+
+	return app.render(w, r, http.StatusOK, "account-delete.tmpl", data)
+}
+
+// Schedule deletion of the account after the grace period and sign out
+// of all sessions. Logging in again before then cancels the deletion.
+func (app *application) handleAccountDeletePost(w http.ResponseWriter, r *http.Request) error {
+	var form struct {
+		Email    string `form:"email" validate:"omitempty,email"`
+		Password string `form:"password" validate:"omitempty,max=72"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	// Users without a password confirm with their email address instead
+	if user.HasPassword() {
+		_, err = app.models.User.GetForCredentials(r.Context(), user.Email, form.Password)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				return FormErrors{"Password": "incorrect password"}
+			}
+
+			return err
+		}
+	} else if !strings.EqualFold(form.Email, user.Email) {
+		return FormErrors{"Email": "does not match your email"}
+	}
+
+	at := time.Now().Add(app.config.deletionGrace)
+	err = app.models.User.ScheduleDeletion(r.Context(), user.ID, at)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Session.DeleteAllForUser(r.Context(), user.ID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return err
+	}
+
+	err = app.logout(r)
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashInfo,
+		Message: "Your account will be deleted on " + at.Format("January 2, 2006") + ". Login before then to cancel.",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+// Periodically delete users whose grace period has ended.
+func (app *application) purgeDeletedUsers(interval time.Duration) {
+	ctx, cancel := app.shutdownContext()
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.models.User.PurgeDeleted(ctx, time.Now())
+		if err != nil {
+			app.logger.Error("purge deleted users", slog.Any("err", err))
+		}
+		if n > 0 {
+			app.logger.Info("purged deleted users", slog.Int("count", n))
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+const testDeletionGrace = 14 * 24 * time.Hour
+
+// Assert the number of users PurgeDeleted deletes at now.
+func assertPurged(t *testing.T, app *application, now time.Time, want int) {
+	t.Helper()
+
+	n, err := app.models.User.PurgeDeleted(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("got %d purged; want %d", n, want)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.deletionGrace = testDeletionGrace
+	ts := newTestServer(t, app.routes())
+	other := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	res = login(t, other, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	t.Run("incorrect password", func(t *testing.T) {
+		res := ts.postForm(t, "/account/delete", url.Values{"password": {"incorrect"}})
+		assertRedirect(t, res, ts.URL+"/")
+		assertAuthenticated(t, ts, true)
+
+		// Nothing scheduled, even long after the grace period
+		assertPurged(t, app, time.Now().Add(2*testDeletionGrace), 0)
+	})
+
+	start := time.Now()
+
+	t.Run("schedule", func(t *testing.T) {
+		res := ts.postForm(t, "/account/delete", url.Values{"password": {testPassword}})
+		assertRedirect(t, res, "/")
+
+		// Signed out of all sessions
+		assertAuthenticated(t, ts, false)
+		assertAuthenticated(t, other, false)
+	})
+
+	t.Run("grace period", func(t *testing.T) {
+		assertPurged(t, app, time.Now(), 0)
+		assertPurged(t, app, start.Add(testDeletionGrace-time.Minute), 0)
+
+		_, err := app.models.User.GetWithID(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("got %v; want user to exist during grace period", err)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		assertPurged(t, app, time.Now().Add(testDeletionGrace), 1)
+
+		_, err := app.models.User.GetWithID(context.Background(), user.ID)
+		if !errors.Is(err, models.ErrNoRecord) {
+			t.Fatalf("got %v; want %v", err, models.ErrNoRecord)
+		}
+
+		res := login(t, ts, testEmail, testPassword)
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+}
+
+func TestAccountDeletionCancelledByLogin(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.deletionGrace = testDeletionGrace
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	res = ts.postForm(t, "/account/delete", url.Values{"password": {testPassword}})
+	assertRedirect(t, res, "/")
+
+	res = login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	res = ts.get(t, "/")
+	assertStatus(t, res, http.StatusOK)
+	if !strings.Contains(res.body, "no longer scheduled for deletion") {
+		t.Fatal("no cancellation message")
+	}
+
+	assertPurged(t, app, time.Now().Add(2*testDeletionGrace), 0)
+
+	_, err = app.models.User.GetWithID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ctx := context.Background()
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// Every user has rows in each table with a purger
+	newUser := func(email string) (*models.User, *testServer) {
+		t.Helper()
+
+		user, err := app.models.User.New(ctx, email, testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts := newTestServer(t, app.routes())
+		res := login(t, ts, email, testPassword)
+		assertRedirect(t, res, "/")
+
+		for _, key := range []string{models.LoginAttemptEmailKey(email), models.LoginAttemptTwoFactorKey(user.ID)} {
+			_, err = app.models.LoginAttempt.Fail(ctx, key, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err = app.models.Verification.New(ctx, email, models.PurposeReset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = app.models.Verification.NewForUser(ctx, user.ID, "new-"+email, models.PurposeEmailChange)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return user, ts
+	}
+
+	user, ts := newUser(testEmail)
+	kept, keptTS := newUser(testNewEmail)
+
+	err := app.models.User.ScheduleDeletion(ctx, user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.User.ScheduleDeletion(ctx, kept.ID, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertPurged(t, app, now.Add(-time.Second), 0)
+	assertPurged(t, app, now, 1)
+
+	t.Run("user", func(t *testing.T) {
+		_, err := app.models.User.GetWithID(ctx, user.ID)
+		if !errors.Is(err, models.ErrNoRecord) {
+			t.Fatalf("got %v; want %v", err, models.ErrNoRecord)
+		}
+
+		_, err = app.models.User.GetWithID(ctx, kept.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("audit events", func(t *testing.T) {
+		for _, f := range []models.AuditFilter{
+			{Email: user.Email},
+			{UserID: uuid.NullUUID{UUID: user.ID, Valid: true}},
+		} {
+			events, err := app.models.AuditEvent.GetAll(ctx, f, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 0 {
+				t.Fatalf("got %d events for %+v; want none", len(events), f)
+			}
+		}
+
+		events, err := app.models.AuditEvent.GetAll(ctx, models.AuditFilter{UserID: uuid.NullUUID{UUID: kept.ID, Valid: true}}, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			t.Fatal("events of other user purged")
+		}
+	})
+
+	t.Run("login attempts", func(t *testing.T) {
+		for _, key := range []string{models.LoginAttemptEmailKey(user.Email), models.LoginAttemptTwoFactorKey(user.ID)} {
+			err := app.models.LoginAttempt.Reset(ctx, key)
+			if !errors.Is(err, models.ErrNoRecord) {
+				t.Fatalf("got %v for %s; want %v", err, key, models.ErrNoRecord)
+			}
+		}
+
+		err := app.models.LoginAttempt.Reset(ctx, models.LoginAttemptEmailKey(kept.Email))
+		if err != nil {
+			t.Fatalf("attempts of other user purged: %v", err)
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		sessions, err := app.models.Session.GetAllForUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 0 {
+			t.Fatalf("got %d sessions; want none", len(sessions))
+		}
+
+		assertAuthenticated(t, ts, false)
+		assertAuthenticated(t, keptTS, true)
+	})
+
+	t.Run("verifications", func(t *testing.T) {
+		_, err := app.models.Verification.Get(ctx, user.Email, models.PurposeReset)
+		if !errors.Is(err, models.ErrNoRecord) {
+			t.Fatalf("got %v; want %v", err, models.ErrNoRecord)
+		}
+
+		_, err = app.models.Verification.GetForUser(ctx, user.ID, models.PurposeEmailChange)
+		if !errors.Is(err, models.ErrNoRecord) {
+			t.Fatalf("got %v; want %v", err, models.ErrNoRecord)
+		}
+
+		_, err = app.models.Verification.Get(ctx, kept.Email, models.PurposeReset)
+		if err != nil {
+			t.Fatalf("verification of other user purged: %v", err)
+		}
+	})
+
+	// The other user is due a second later
+	assertPurged(t, app, now.Add(time.Second), 1)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/micahco/web/internal/mailer"
+)
+
+var linkRX = regexp.MustCompile(`https?://\S+`)
+
+// Dev mode mail catcher. Lists messages captured by the mailbox.
+func (app *application) handleDevMailGet(w http.ResponseWriter, r *http.Request) error {
+	return app.render(w, r, http.StatusOK, "dev-mail.tmpl", app.mailbox.Messages())
+}
+
+func (app *application) devMailMessage(w http.ResponseWriter, r *http.Request) (*mailer.MailboxMessage, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	m := app.mailbox.Get(id)
+	if m == nil {
+		return nil, app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	return m, nil
+}
+
+func (app *application) handleDevMailMessageGet(w http.ResponseWriter, r *http.Request) error {
+	m, err := app.devMailMessage(w, r)
+	if m == nil {
+		return err
+	}
+
+	var data struct {
+		Message *mailer.MailboxMessage
+		Links   []string
+	}
+	data.Message = m
+	data.Links = linkRX.FindAllString(m.Text, -1)
+
+	return app.render(w, r, http.StatusOK, "dev-mail-message.tmpl", data)
+}
+
+// Serve the HTML body to be framed by the message page
+func (app *application) handleDevMailHTMLGet(w http.ResponseWriter, r *http.Request) error {
+	m, err := app.devMailMessage(w, r)
+	if m == nil {
+		return err
+	}
+
+	// Mail uses inline styles and is shown in a frame of the message page
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'none'; style-src 'unsafe-inline'; img-src * data:; frame-ancestors 'self'; sandbox allow-popups allow-top-navigation-by-user-activation;")
+	w.Header().Set("X-Frame-Options", "sameorigin")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	_, err = w.Write([]byte(m.HTML))
+
+	return err
+}
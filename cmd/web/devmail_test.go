@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDevMailNotMounted(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	for _, path := range []string{"/dev/mail/", "/dev/mail/1", "/dev/mail/1/html"} {
+		res := ts.get(t, path)
+		assertStatus(t, res, http.StatusNotFound)
+	}
+}
+
+func TestDevMail(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	// As in dev mode, where mail is captured
+	app.mailbox = mailbox
+	ts := newTestServer(t, app.routes())
+
+	res := ts.get(t, "/dev/mail/")
+	assertStatus(t, res, http.StatusOK)
+	if strings.Contains(res.body, testEmail) {
+		t.Fatal("mail listed before any was sent")
+	}
+
+	res = ts.postForm(t, "/auth/signup", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	msgs := mailbox.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages; want 1", len(msgs))
+	}
+
+	res = ts.get(t, "/dev/mail/")
+	assertStatus(t, res, http.StatusOK)
+	for _, want := range []string{testEmail, msgs[0].Subject} {
+		if !strings.Contains(res.body, want) {
+			t.Fatalf("no %q in mail list", want)
+		}
+	}
+
+	res = ts.get(t, "/dev/mail/1")
+	assertStatus(t, res, http.StatusOK)
+	if !strings.Contains(res.body, "/auth/register?token=") {
+		t.Fatal("no register link in message page")
+	}
+
+	res = ts.get(t, "/dev/mail/1/html")
+	assertStatus(t, res, http.StatusOK)
+	if csp := res.header.Get("Content-Security-Policy"); !strings.Contains(csp, "sandbox") {
+		t.Fatalf("got CSP %q; want sandboxed mail", csp)
+	}
+
+	for _, path := range []string{"/dev/mail/2", "/dev/mail/x"} {
+		res := ts.get(t, path)
+		assertStatus(t, res, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+func (app *application) handleAccountEmailGet(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	return app.render(w, r, http.StatusOK, "account-email.tmpl", userData{Email: user.Email})
+}
+
+// Request change of email address. A confirmation link is sent to the new
+// address, and a notice with a link to cancel the change to the old one.
+func (app *application) handleAccountEmailPost(w http.ResponseWriter, r *http.Request) error {
+	var form struct {
+		Email string `form:"email" validate:"required,email,max=254"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(form.Email, user.Email) {
+		return FormErrors{"Email": "same as current email"}
+	}
+
+	f := FlashMessage{
+		Type:    FlashInfo,
+		Message: "A link to confirm the change has been sent to your new email address. Please check your junk folder.",
+	}
+
+	// Don't send a new link if less than 5 minutes since last
+	v, err := app.models.Verification.GetForUser(r.Context(), user.ID, models.PurposeEmailChange)
+	if err != nil && err != models.ErrNoRecord {
+		return err
+	}
+
+	if v != nil {
+		if time.Since(v.CreatedAt) < 5*time.Minute {
+			app.putFlash(r, f)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+
+			return nil
+		}
+	}
+
+	// A new request replaces any pending change
+	for _, p := range []models.VerificationPurpose{models.PurposeEmailChange, models.PurposeEmailChangeCancel} {
+		err = app.models.Verification.PurgeForUser(r.Context(), user.ID, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err := app.models.Verification.NewForUser(r.Context(), user.ID, form.Email, models.PurposeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	cancelToken, err := app.models.Verification.NewForUser(r.Context(), user.ID, user.Email, models.PurposeEmailChangeCancel)
+	if err != nil {
+		return err
+	}
+
+	err = app.sendMail(r, form.Email, "email-change.tmpl", app.tokenLink("/account/email/confirm", token))
+	if err != nil {
+		return err
+	}
+
+	err = app.sendMail(r, user.Email, "email-change-notice.tmpl", app.tokenLink("/account/email/cancel", cancelToken))
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, f)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+// Redeem token of the posted form for purpose that belongs to a user.
+// Renders an error and returns nil verification if the token is not valid.
+func (app *application) redeemUserToken(w http.ResponseWriter, r *http.Request, purpose models.VerificationPurpose) (*models.Verification, error) {
+	var form struct {
+		Token string `form:"token" validate:"required"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := app.models.Verification.Redeem(r.Context(), form.Token, purpose)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+		if errors.Is(err, models.ErrExpiredVerification) {
+			app.putFlash(r, ExpiredTokenFlash)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !v.UserID.Valid {
+		return nil, app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	}
+
+	return v, nil
+}
+
+func (app *application) handleAccountEmailConfirmGet(w http.ResponseWriter, r *http.Request) error {
+	return app.renderTokenConfirm(w, r, tokenConfirmData{
+		Title:   "Confirm Email",
+		Message: "Confirm the change of your account's email address to this address.",
+		Action:  "/account/email/confirm",
+		Button:  "Confirm",
+	})
+}
+
+func (app *application) handleAccountEmailConfirmPost(w http.ResponseWriter, r *http.Request) error {
+	v, err := app.redeemUserToken(w, r, models.PurposeEmailChange)
+	if v == nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), v.UserID.UUID)
+	if err != nil {
+		return err
+	}
+
+	// The cancel link sent to the old address stays valid, so the owner
+	// can still revert the change if they didn't request it.
+	user.Email = v.Email
+	err = app.models.User.Update(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.putFlash(r, FlashMessage{
+				Type:    FlashError,
+				Message: "That email address is already in use by another account.",
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+
+			return nil
+		}
+
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Successfully updated email address.",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) handleAccountEmailCancelGet(w http.ResponseWriter, r *http.Request) error {
+	return app.renderTokenConfirm(w, r, tokenConfirmData{
+		Title:   "Cancel Email Change",
+		Message: "Keep this email address for your account. If the change was already confirmed, it is reverted and all sessions are signed out.",
+		Action:  "/account/email/cancel",
+		Button:  "Cancel change",
+	})
+}
+
+// Cancel a pending email change, or revert it if it was already confirmed.
+// Whoever confirmed it may have taken over the account, so reverting also
+// signs out all sessions.
+func (app *application) handleAccountEmailCancelPost(w http.ResponseWriter, r *http.Request) error {
+	v, err := app.redeemUserToken(w, r, models.PurposeEmailChangeCancel)
+	if v == nil {
+		return err
+	}
+
+	err = app.models.Verification.PurgeForUser(r.Context(), v.UserID.UUID, models.PurposeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), v.UserID.UUID)
+	if err != nil {
+		return err
+	}
+
+	f := FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Email address change cancelled.",
+	}
+
+	if !strings.EqualFold(user.Email, v.Email) {
+		changed := user.Email
+
+		user.Email = v.Email
+		err = app.models.User.Update(r.Context(), user)
+		if err != nil {
+			if errors.Is(err, models.ErrDuplicateEmail) {
+				app.putFlash(r, FlashMessage{
+					Type:    FlashError,
+					Message: "Your previous email address is now in use by another account.",
+				})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+
+				return nil
+			}
+
+			return err
+		}
+
+		// Links sent to the changed address no longer belong to the user
+		for _, p := range []models.VerificationPurpose{models.PurposeReset, models.PurposeMagicLogin} {
+			err = app.models.Verification.Purge(r.Context(), changed, p)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = app.models.Session.DeleteAllForUser(r.Context(), user.ID, app.sessionManager.Token(r.Context()))
+		if err != nil {
+			return err
+		}
+
+		if suid, err := app.getSessionUserID(r); err == nil && suid == user.ID {
+			err = app.logout(r)
+			if err != nil {
+				return err
+			}
+		}
+
+		f.Message = "Email address change reverted and all sessions signed out. Consider resetting your password."
+	}
+
+	app.putFlash(r, f)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
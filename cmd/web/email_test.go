@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/micahco/web/internal/mailer"
+)
+
+const testNewEmail = "bob@example.com"
+
+// Request a change of the email address of the logged in user and return
+// the confirm and cancel tokens.
+func requestEmailChange(t *testing.T, ts *testServer, mailbox *mailer.Mailbox) (confirm, cancel string) {
+	t.Helper()
+
+	res := ts.postForm(t, "/account/email", url.Values{"email": {testNewEmail}})
+	assertRedirect(t, res, "/")
+
+	token := func(recipient, prefix string) string {
+		link := mailLink(t, mailbox, recipient)
+
+		// Following the link, e.g. by a mail scanner, doesn't use it up
+		res := ts.get(t, link)
+		assertStatus(t, res, http.StatusOK)
+
+		token := strings.TrimPrefix(link, prefix)
+		if token == link {
+			t.Fatalf("got link %q; want %s link", link, prefix)
+		}
+
+		return token
+	}
+
+	return token(testNewEmail, "/account/email/confirm?token="), token(testEmail, "/account/email/cancel?token=")
+}
+
+func assertEmail(t *testing.T, app *application, email string) {
+	t.Helper()
+
+	_, err := app.models.User.GetWithEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("get user with email %s: %v", email, err)
+	}
+}
+
+func TestAccountEmailChange(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	confirm, _ := requestEmailChange(t, ts, mailbox)
+	assertEmail(t, app, testEmail)
+
+	res = ts.postForm(t, "/account/email/confirm", url.Values{"token": {confirm}})
+	assertRedirect(t, res, "/")
+	assertEmail(t, app, testNewEmail)
+	assertAuthenticated(t, ts, true)
+
+	res = ts.postForm(t, "/account/email/confirm", url.Values{"token": {confirm}})
+	assertStatus(t, res, http.StatusUnauthorized)
+}
+
+func TestAccountEmailChangeRevert(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	confirm, cancel := requestEmailChange(t, ts, mailbox)
+
+	res = ts.postForm(t, "/account/email/confirm", url.Values{"token": {confirm}})
+	assertRedirect(t, res, "/")
+	assertEmail(t, app, testNewEmail)
+
+	// The owner of the old address reverts the change from another client,
+	// which signs out the session that confirmed it
+	owner := newTestServer(t, app.routes())
+
+	res = owner.postForm(t, "/account/email/cancel", url.Values{"token": {cancel}})
+	assertRedirect(t, res, "/")
+	assertEmail(t, app, testEmail)
+	assertAuthenticated(t, ts, false)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/micahco/web/migrations"
+)
+
+const readinessTimeout = 3 * time.Second
+
+// Liveness probe. The process is alive if it can respond.
+func (app *application) handleHealthzGet(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness probe. Checks the dependencies needed to serve requests. All
+// checks share readinessTimeout.
+func (app *application) handleReadyzGet(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func() error{
+		"database": func() error {
+			return app.models.Schema.Ping(ctx)
+		},
+		"mail": func() error {
+			return app.mailQueue.Ping(ctx)
+		},
+		"migrations": func() error {
+			return app.checkMigrations(ctx)
+		},
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		err := check()
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+
+			continue
+		}
+
+		results[name] = "ok"
+	}
+
+	data := map[string]any{
+		"status": "ok",
+		"checks": results,
+	}
+	if status != http.StatusOK {
+		data["status"] = "unavailable"
+	}
+
+	return writeJSON(w, status, data)
+}
+
+// Check that the database is at the latest embedded migration.
+func (app *application) checkMigrations(ctx context.Context) error {
+	want, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := app.models.Schema.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("migration %d is dirty", version)
+	}
+
+	if version != want {
+		return fmt.Errorf("at version %d, expected %d", version, want)
+	}
+
+	return nil
+}
+
+func (app *application) handleVersionGet(w http.ResponseWriter, r *http.Request) error {
+	data := map[string]string{}
+
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		data["version"] = bi.Main.Version
+		data["go"] = bi.GoVersion
+
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				data["revision"] = s.Value
+			case "vcs.time":
+				data["time"] = s.Value
+			case "vcs.modified":
+				data["modified"] = s.Value
+			}
+		}
+	}
+
+	return writeJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/micahco/web/internal/mailer"
+	"github.com/micahco/web/migrations"
+)
+
+type testSchema struct {
+	pingErr error
+	version uint
+	dirty   bool
+}
+
+func (s testSchema) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s testSchema) Version(ctx context.Context) (uint, bool, error) {
+	return s.version, s.dirty, nil
+}
+
+// Start an SMTP server that accepts every command until QUIT.
+func newTestSMTPServer(t *testing.T) (host string, port int) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					if strings.HasPrefix(line, "QUIT") {
+						conn.Write([]byte("221 Bye\r\n"))
+						return
+					}
+
+					conn.Write([]byte("250 localhost\r\n"))
+				}
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+
+	return addr.IP.String(), addr.Port
+}
+
+// Get an address that refuses connections.
+func closedAddr(t *testing.T) (host string, port int) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	return addr.IP.String(), addr.Port
+}
+
+func TestReadyz(t *testing.T) {
+	latest, err := migrations.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port := newTestSMTPServer(t)
+	closedHost, closedPort := closedAddr(t)
+
+	tests := []struct {
+		name      string
+		schema    testSchema
+		transport mailer.Transport
+		status    int
+		// Check that fails, if any
+		failed string
+	}{
+		{"ready", testSchema{version: latest}, mailer.NewSMTPTransport(host, port, "", ""), http.StatusOK, ""},
+		{"database down", testSchema{pingErr: errors.New("connection refused"), version: latest}, mailer.NewSMTPTransport(host, port, "", ""), http.StatusServiceUnavailable, "database"},
+		{"old migration", testSchema{version: latest - 1}, mailer.NewSMTPTransport(host, port, "", ""), http.StatusServiceUnavailable, "migrations"},
+		{"dirty migration", testSchema{version: latest, dirty: true}, mailer.NewSMTPTransport(host, port, "", ""), http.StatusServiceUnavailable, "migrations"},
+		{"mail unreachable", testSchema{version: latest}, mailer.NewSMTPTransport(closedHost, closedPort, "", ""), http.StatusServiceUnavailable, "mail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			app.models.Schema = tt.schema
+			app.mailQueue = newMailQueue(nil, tt.transport, app.logger)
+
+			ts := newTestServer(t, app.routes())
+
+			res := ts.get(t, "/readyz")
+			assertStatus(t, res, tt.status)
+
+			var data struct {
+				Checks map[string]string `json:"checks"`
+			}
+
+			err := json.Unmarshal([]byte(res.body), &data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"database", "mail", "migrations"} {
+				got := data.Checks[name]
+				if name == tt.failed {
+					if got == "ok" || got == "" {
+						t.Errorf("got %s check %q; want error", name, got)
+					}
+				} else if got != "ok" {
+					t.Errorf("got %s check %q; want ok", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPTransportPing(t *testing.T) {
+	host, port := newTestSMTPServer(t)
+
+	err := mailer.NewSMTPTransport(host, port, "", "").Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled context is honored
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = mailer.NewSMTPTransport(host, port, "", "").Ping(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v; want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/micahco/web/internal/models"
+)
+
+const (
+	// Failed attempts before an account or client IP is locked out.
+	// Clients get more, since they may be shared by many users.
+	accountLockoutThreshold = 5
+	ipLockoutThreshold      = 20
+	// Failed second factor codes before the pending login is dropped and
+	// the user has to start over with their password.
+	twoFactorLockoutThreshold = 5
+)
+
+// Keys of the account and the client that login attempts are tracked
+// by. Clients are tracked by IP address, without the port, which differs
+// between connections.
+func loginAttemptKeys(r *http.Request, email string) (emailKey, ipKey string) {
+	return models.LoginAttemptEmailKey(email), models.LoginAttemptIPKey(clientIP(r))
+}
+
+// Record failed login for account and client. Notifies the owner of the
+// account when it gets locked out.
+func (app *application) recordLoginFailure(r *http.Request, email, emailKey, ipKey string) error {
+	_, err := app.models.LoginAttempt.Fail(r.Context(), ipKey, ipLockoutThreshold)
+	if err != nil {
+		return err
+	}
+
+	locked, err := app.models.LoginAttempt.Fail(r.Context(), emailKey, accountLockoutThreshold)
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		return nil
+	}
+
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), email)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		link := app.baseURL.ResolveReference(&url.URL{Path: "/auth/reset"})
+		return app.sendMail(r, email, "account-locked.tmpl", link)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+func (app *application) handleAuthMagicPost(w http.ResponseWriter, r *http.Request) error {
+	if app.isAuthenticated(r) {
+		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
+	}
+
+	var form struct {
+		Email string `form:"email" validate:"required,email"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	// Consistent flash message
+	f := FlashMessage{
+		Type:    FlashInfo,
+		Message: "A login link has been sent to the email address provided. Please check your junk folder.",
+	}
+
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), form.Email)
+	if err != nil {
+		return err
+	}
+
+	// If user does not exist, do nothing but send flash message
+	if !exists {
+		app.putFlash(r, f)
+		app.refresh(w, r)
+
+		return nil
+	}
+
+	// Check if link verification has already been created
+	v, err := app.models.Verification.Get(r.Context(), form.Email, models.PurposeMagicLogin)
+	if err != nil && err != models.ErrNoRecord {
+		return err
+	}
+
+	// Don't send a new link if less than 5 minutes since last
+	if v != nil {
+		if time.Since(v.CreatedAt) < 5*time.Minute {
+			app.putFlash(r, f)
+			app.refresh(w, r)
+
+			return nil
+		}
+	}
+
+	token, err := app.models.Verification.New(r.Context(), form.Email, models.PurposeMagicLogin)
+	if err != nil {
+		return err
+	}
+
+	link := app.tokenLink("/auth/magic", token)
+	err = app.sendMail(r, form.Email, "magic-login.tmpl", link)
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, f)
+	app.refresh(w, r)
+
+	return nil
+}
+
+func (app *application) handleAuthMagicGet(w http.ResponseWriter, r *http.Request) error {
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+		return nil
+	}
+
+	return app.renderTokenConfirm(w, r, tokenConfirmData{
+		Title:   "Login",
+		Message: "Continue to login with the link sent to your email.",
+		Action:  "/auth/magic/login",
+		Button:  "Login",
+	})
+}
+
+func (app *application) handleAuthMagicLoginPost(w http.ResponseWriter, r *http.Request) error {
+	if app.isAuthenticated(r) {
+		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
+	}
+
+	var form struct {
+		Token string `form:"token" validate:"required"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	v, err := app.models.Verification.Redeem(r.Context(), form.Token, models.PurposeMagicLogin)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+		if errors.Is(err, models.ErrExpiredVerification) {
+			app.putFlash(r, ExpiredTokenFlash)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+
+			return nil
+		}
+
+		return err
+	}
+
+	user, err := app.models.User.GetWithEmail(r.Context(), v.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+
+		return err
+	}
+
+	// Invalidate any other login links that were sent
+	err = app.models.Verification.Purge(r.Context(), v.Email, models.PurposeMagicLogin)
+	if err != nil {
+		return err
+	}
+
+	return app.loginWithSecondFactor(w, r, user)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMagicLogin(t *testing.T) {
+	app, mailbox := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ts.postForm(t, "/auth/magic", url.Values{"email": {testEmail}})
+	assertRedirect(t, res, ts.URL+"/")
+
+	link := mailLink(t, mailbox, testEmail)
+	token := strings.TrimPrefix(link, "/auth/magic?token=")
+	if token == link {
+		t.Fatalf("got link %q; want magic login link", link)
+	}
+
+	// Following the link, e.g. by a mail scanner, doesn't use it up
+	for range 2 {
+		res = ts.get(t, link)
+		assertStatus(t, res, http.StatusOK)
+		assertAuthenticated(t, ts, false)
+	}
+
+	res = ts.postForm(t, "/auth/magic/login", url.Values{"token": {token}})
+	assertRedirect(t, res, "/")
+	assertAuthenticated(t, ts, true)
+
+	res = ts.postForm(t, "/auth/logout", nil)
+	assertRedirect(t, res, "/")
+
+	// But logging in does
+	res = ts.postForm(t, "/auth/magic/login", url.Values{"token": {token}})
+	assertStatus(t, res, http.StatusUnauthorized)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/micahco/web/internal/mailer"
+	"github.com/micahco/web/internal/models"
+)
+
+const (
+	mailMaxAttempts  = 8
+	mailRetryBackoff = 30 * time.Second
+	mailMaxBackoff   = time.Hour
+	mailPollInterval = 5 * time.Second
+	// Time a worker has to send a claimed message before it is due again
+	mailLease = 5 * time.Minute
+	// Failed messages are kept for inspection in the admin mail page, but
+	// they may contain tokens, so not for long.
+	mailFailedRetention = 7 * 24 * time.Hour
+)
+
+// Mail transport that stores messages in the outbox. Workers send them
+// with the underlying transport, retrying with backoff on failure.
+type mailQueue struct {
+	outbox    models.MailOutboxStore
+	transport mailer.Transport
+	now       func() time.Time
+	logger    *slog.Logger
+	wake      chan struct{}
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	// Context of queries made by the workers, cancelled if draining the
+	// queue takes longer than the shutdown allows.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newMailQueue(outbox models.MailOutboxStore, transport mailer.Transport, logger *slog.Logger) *mailQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &mailQueue{
+		outbox:    outbox,
+		transport: transport,
+		now:       time.Now,
+		logger:    logger,
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (q *mailQueue) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	err := q.outbox.Enqueue(ctx, from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	// Wake an idle worker without blocking
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Ping the underlying transport, if it supports it.
+func (q *mailQueue) Ping(ctx context.Context) error {
+	if p, ok := q.transport.(mailer.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// Start n workers.
+func (q *mailQueue) Start(n int) {
+	for range n {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop workers after the messages that are due have been sent, or ctx
+// is done.
+func (q *mailQueue) Shutdown(ctx context.Context) error {
+	close(q.quit)
+	defer q.cancel()
+
+	return wait(ctx, &q.wg)
+}
+
+func (q *mailQueue) work() {
+	defer q.wg.Done()
+
+	for {
+		n, err := q.sendDue()
+		if err != nil {
+			q.logger.Error("mail queue", slog.Any("err", err))
+		}
+
+		// Keep sending while there are due messages, which also drains
+		// the queue after quit.
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-q.quit:
+			return
+		case <-q.wake:
+		case <-time.After(mailPollInterval):
+		}
+	}
+}
+
+// Claim and send a due message. Returns the number of messages claimed.
+func (q *mailQueue) sendDue() (int, error) {
+	msgs, err := q.outbox.Claim(q.ctx, q.now(), 1, mailLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range msgs {
+		err = q.send(m)
+		if err != nil {
+			return len(msgs), err
+		}
+	}
+
+	return len(msgs), nil
+}
+
+func (q *mailQueue) send(m *models.OutboxMessage) error {
+	err := q.transport.Send(q.ctx, m.Sender, m.Recipients, m.Message)
+	if err == nil {
+		return q.outbox.MarkSent(q.ctx, m.ID)
+	}
+
+	if m.Attempts >= mailMaxAttempts {
+		q.logger.Error("mail failed", slog.Int64("id", m.ID), slog.Int("attempts", m.Attempts), slog.Any("err", err))
+
+		return q.outbox.MarkFailed(q.ctx, m.ID, err.Error())
+	}
+
+	next := q.now().Add(mailBackoff(m.Attempts))
+	q.logger.Warn("mail retry", slog.Int64("id", m.ID), slog.Int("attempts", m.Attempts), slog.Time("next", next), slog.Any("err", err))
+
+	return q.outbox.MarkRetry(q.ctx, m.ID, err.Error(), next)
+}
+
+// Backoff after attempt, doubling from mailRetryBackoff up to mailMaxBackoff.
+func mailBackoff(attempt int) time.Duration {
+	d := mailRetryBackoff
+	for i := 1; i < attempt && d < mailMaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, mailMaxBackoff)
+}
+
+// Periodically delete messages that failed longer than
+// mailFailedRetention ago.
+func (app *application) purgeFailedMail(interval time.Duration) {
+	ctx, cancel := app.shutdownContext()
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.models.MailOutbox.DeleteFailed(ctx, time.Now().Add(-mailFailedRetention))
+		if err != nil {
+			app.logger.Error("purge failed mail", slog.Any("err", err))
+		}
+		if n > 0 {
+			app.logger.Info("purged failed mail", slog.Int("count", n))
+		}
+	}
+}
+
+func (app *application) handleAdminMailGet(w http.ResponseWriter, r *http.Request) error {
+	msgs, err := app.models.MailOutbox.GetStuck(r.Context(), adminPageSize)
+	if err != nil {
+		return err
+	}
+
+	type messageData struct {
+		*models.OutboxMessage
+		Subject string
+	}
+
+	data := make([]messageData, len(msgs))
+	for i, m := range msgs {
+		data[i] = messageData{m, mailSubject(m.Message)}
+	}
+
+	return app.render(w, r, http.StatusOK, "admin-mail.tmpl", data)
+}
+
+// Get decoded subject header of raw message
+func mailSubject(msg []byte) string {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return ""
+	}
+
+	subject := m.Header.Get("Subject")
+	decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+	if err != nil {
+		return subject
+	}
+
+	return decoded
+}
+
+func (app *application) handleAdminMailRetryPost(w http.ResponseWriter, r *http.Request) error {
+	return app.updateOutboxMessage(w, r, app.models.MailOutbox.Retry, "Queued message for retry.")
+}
+
+func (app *application) handleAdminMailDeletePost(w http.ResponseWriter, r *http.Request) error {
+	return app.updateOutboxMessage(w, r, app.models.MailOutbox.Delete, "Deleted message.")
+}
+
+func (app *application) updateOutboxMessage(w http.ResponseWriter, r *http.Request, update func(context.Context, int64) error, msg string) error {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	}
+
+	err = update(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: msg,
+	})
+	http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+
+	return nil
+}
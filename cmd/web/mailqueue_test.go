@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+const testQueueMessage = "From: noreply@example.com\r\n" +
+	"To: alice@example.com\r\n" +
+	"Subject: Queued\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+var errTransport = errors.New("transport unavailable")
+
+// Transport that fails a number of sends before it delivers. Negative
+// failures fail every send.
+type flakyTransport struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     [][]byte
+}
+
+func (t *flakyTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts++
+	if t.failures != 0 {
+		t.failures--
+
+		return errTransport
+	}
+
+	t.sent = append(t.sent, msg)
+
+	return nil
+}
+
+func (t *flakyTransport) counts() (attempts, sent int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.attempts, len(t.sent)
+}
+
+func newTestMailQueue(t *testing.T, transport *flakyTransport) *mailQueue {
+	t.Helper()
+
+	outbox := models.NewMemory(nil).MailOutbox
+	q := newMailQueue(outbox, transport, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Cleanup(q.cancel)
+
+	return q
+}
+
+func sendTestMessage(t *testing.T, q *mailQueue) {
+	t.Helper()
+
+	err := q.Send(context.Background(), "noreply@example.com", []string{testEmail}, []byte(testQueueMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Get the only message left in the outbox
+func stuckMessage(t *testing.T, q *mailQueue) *models.OutboxMessage {
+	t.Helper()
+
+	msgs, err := q.outbox.GetStuck(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d stuck messages; want 1", len(msgs))
+	}
+
+	return msgs[0]
+}
+
+func assertOutboxEmpty(t *testing.T, q *mailQueue) {
+	t.Helper()
+
+	msgs, err := q.outbox.Claim(context.Background(), time.Now().Add(mailMaxBackoff), 10, mailLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stuck, err := q.outbox.GetStuck(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs)+len(stuck) != 0 {
+		t.Fatalf("got %d due and %d stuck messages; want none", len(msgs), len(stuck))
+	}
+}
+
+func TestMailQueueDelivery(t *testing.T) {
+	tr := &flakyTransport{}
+	q := newTestMailQueue(t, tr)
+	q.Start(1)
+
+	sendTestMessage(t, q)
+
+	// Send wakes the idle worker, well before the poll interval
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, sent := tr.counts(); sent == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message not delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err := q.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(tr.sent[0]); got != testQueueMessage {
+		t.Fatalf("got message %q; want %q", got, testQueueMessage)
+	}
+
+	// Sent messages are not kept
+	assertOutboxEmpty(t, q)
+}
+
+func TestMailQueueRetry(t *testing.T) {
+	tr := &flakyTransport{failures: 2}
+	q := newTestMailQueue(t, tr)
+
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	// Enqueued before the fixed clock
+	sendTestMessage(t, q)
+
+	for attempt, backoff := range []time.Duration{30 * time.Second, time.Minute} {
+		n, err := q.sendDue()
+		if n != 1 || err != nil {
+			t.Fatalf("attempt %d: got %d, %v; want 1 message", attempt+1, n, err)
+		}
+
+		m := stuckMessage(t, q)
+		if m.Attempts != attempt+1 || m.LastError != errTransport.Error() {
+			t.Fatalf("got attempts %d and error %q; want %d and %q", m.Attempts, m.LastError, attempt+1, errTransport)
+		}
+		if want := now.Add(backoff); !m.NextAttemptAt.Equal(want) {
+			t.Fatalf("got next attempt at %v; want %v", m.NextAttemptAt, want)
+		}
+
+		// Not due until the backoff has passed
+		n, err = q.sendDue()
+		if n != 0 || err != nil {
+			t.Fatalf("got %d, %v; want no due messages", n, err)
+		}
+
+		now = m.NextAttemptAt
+	}
+
+	n, err := q.sendDue()
+	if n != 1 || err != nil {
+		t.Fatalf("got %d, %v; want 1 message", n, err)
+	}
+
+	if attempts, sent := tr.counts(); attempts != 3 || sent != 1 {
+		t.Fatalf("got %d attempts and %d sent; want 3 and 1", attempts, sent)
+	}
+
+	assertOutboxEmpty(t, q)
+}
+
+func TestMailQueueGiveUp(t *testing.T) {
+	tr := &flakyTransport{failures: -1}
+	q := newTestMailQueue(t, tr)
+
+	sendTestMessage(t, q)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	for range mailMaxAttempts {
+		n, err := q.sendDue()
+		if n != 1 || err != nil {
+			t.Fatalf("got %d, %v; want 1 message", n, err)
+		}
+
+		now = now.Add(mailMaxBackoff)
+	}
+
+	m := stuckMessage(t, q)
+	if m.FailedAt == nil || m.Attempts != mailMaxAttempts {
+		t.Fatalf("got failed at %v after %d attempts; want failed after %d", m.FailedAt, m.Attempts, mailMaxAttempts)
+	}
+
+	// Failed messages are not sent again
+	n, err := q.sendDue()
+	if n != 0 || err != nil {
+		t.Fatalf("got %d, %v; want no due messages", n, err)
+	}
+
+	if attempts, _ := tr.counts(); attempts != mailMaxAttempts {
+		t.Fatalf("got %d attempts; want %d", attempts, mailMaxAttempts)
+	}
+
+	// Kept until the retention period has passed
+	n, err = q.outbox.DeleteFailed(context.Background(), m.FailedAt.Add(-time.Second))
+	if n != 0 || err != nil {
+		t.Fatalf("got %d, %v; want none deleted", n, err)
+	}
+
+	n, err = q.outbox.DeleteFailed(context.Background(), m.FailedAt.Add(time.Second))
+	if n != 1 || err != nil {
+		t.Fatalf("got %d, %v; want 1 deleted", n, err)
+	}
+}
+
+func TestMailQueueShutdownDrains(t *testing.T) {
+	tr := &flakyTransport{}
+	q := newTestMailQueue(t, tr)
+
+	for range 3 {
+		sendTestMessage(t, q)
+	}
+
+	q.Start(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := q.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, sent := tr.counts(); sent != 3 {
+		t.Fatalf("got %d sent; want 3", sent)
+	}
+
+	assertOutboxEmpty(t, q)
+}
+
+// Outbox that fails when the context of the request is done
+type contextOutbox struct {
+	models.MailOutboxStore
+}
+
+func (o contextOutbox) Enqueue(ctx context.Context, sender string, recipients []string, msg []byte) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return o.MailOutboxStore.Enqueue(ctx, sender, recipients, msg)
+}
+
+func TestMailQueueSendContext(t *testing.T) {
+	q := newTestMailQueue(t, &flakyTransport{})
+	q.outbox = contextOutbox{q.outbox}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := q.Send(ctx, "noreply@example.com", []string{testEmail}, []byte(testQueueMessage))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestMailBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := mailBackoff(tt.attempt); got != tt.want {
+			t.Errorf("mailBackoff(%d) = %v; want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestAdminMail(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts, _ := newRoleServer(t, app, testEmail, models.RoleAdmin)
+	ctx := context.Background()
+
+	err := app.models.MailOutbox.Enqueue(ctx, "noreply@example.com", []string{testNewEmail}, []byte(testQueueMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := app.models.MailOutbox.Claim(ctx, time.Now(), 1, mailLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.MailOutbox.MarkFailed(ctx, msgs[0].ID, errTransport.Error())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ts.get(t, "/admin/mail")
+	assertStatus(t, res, http.StatusOK)
+	for _, want := range []string{testNewEmail, "Queued", errTransport.Error()} {
+		if !strings.Contains(res.body, want) {
+			t.Fatalf("no %q in mail queue page", want)
+		}
+	}
+
+	res = ts.postForm(t, "/admin/mail/1/retry", nil)
+	assertRedirect(t, res, "/admin/mail")
+
+	msgs, err = app.models.MailOutbox.Claim(ctx, time.Now(), 1, mailLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Attempts != 1 {
+		t.Fatalf("got %v; want message due with attempts reset", msgs)
+	}
+
+	res = ts.postForm(t, "/admin/mail/1/delete", nil)
+	assertRedirect(t, res, "/admin/mail")
+
+	res = ts.postForm(t, "/admin/mail/1/delete", nil)
+	assertStatus(t, res, http.StatusNotFound)
+}
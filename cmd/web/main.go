@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -11,12 +12,14 @@ import (
 	"net/mail"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/scs/pgxstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"github.com/go-playground/validator/v10"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lmittmann/tint"
@@ -25,100 +28,132 @@ import (
 	"github.com/micahco/web/ui"
 )
 
-type config struct {
-	port int
-	dev  bool
-	db   struct {
-		dsn string
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-}
-
 type application struct {
 	baseURL        *url.URL
 	config         config
 	logger         *slog.Logger
 	mailer         *mailer.Mailer
+	mailQueue      *mailQueue
+	mailbox        *mailer.Mailbox
+	metrics        *metrics
 	models         models.Models
+	oidc           *oidcProvider
 	sessionManager *scs.SessionManager
 	templateCache  map[string]*template.Template
+	webauthn       *webauthn.WebAuthn
 	formDecoder    *form.Decoder
 	validate       *validator.Validate
+	shutdown       chan struct{}
+	wg             sync.WaitGroup
 }
 
 func main() {
-	var cfg config
-	var urlstr string
-
-	// Default flag values for production
-	flag.IntVar(&cfg.port, "port", 8080, "API server port")
-	flag.BoolVar(&cfg.dev, "dev", false, "Development mode")
-	flag.StringVar(&urlstr, "url", "", "Base URL")
-
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-user", "", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-pass", "", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-addr", "", "SMTP sender address")
+		fmt.Fprintln(os.Stderr, "invalid config:", err)
+		os.Exit(2)
+	}
 
-	flag.Parse()
+	if cfg.printConfig {
+		os.Exit(0)
+	}
 
-	// Logger
-	h := newSlogHandler(cfg)
+	// Logger, with trace IDs of the logging context
+	h := traceHandler{newSlogHandler(cfg)}
 	logger := slog.New(h)
 	// Create error log for http.Server
 	errLog := slog.NewLogLogger(h, slog.LevelError)
 
 	// Base URL
-	baseURL, err := url.Parse(urlstr)
+	baseURL, err := url.Parse(cfg.url)
 	if err != nil {
 		logger.Error("unable to parse url", slog.Any("err", err))
 		os.Exit(1)
 	}
 
+	// WebAuthn relying party
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: baseURL.Hostname(),
+		RPID:          baseURL.Hostname(),
+		RPOrigins:     []string{baseURL.Scheme + "://" + baseURL.Host},
+	})
+	if err != nil {
+		logger.Error("unable to configure webauthn", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	// OpenID Connect provider
+	var op *oidcProvider
+	if cfg.oidc.issuer != "" {
+		logger.Debug("discovering OIDC provider...")
+		op, err = newOIDCProvider(cfg, baseURL)
+		if err != nil {
+			logger.Error("unable to discover oidc provider", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	// Tracing
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.Error("unable to setup tracing", slog.Any("err", err))
+		os.Exit(1)
+	}
+
 	// PostgreSQL
 	pool, err := openPool(cfg)
 	if err != nil {
 		logger.Error("unable to open pgpool", slog.Any("err", err))
 		os.Exit(1)
 	}
-	defer pool.Close()
+
+	models := models.New(pool)
+
+	// Metrics
+	metrics := newMetrics(pool, models.Session, logger)
 
 	// Mailer
 	sender := &mail.Address{
 		Name:    "Do Not Reply",
 		Address: cfg.smtp.sender,
 	}
-	logger.Debug("dialing SMTP server...")
-	mailer, err := mailer.New(
-		cfg.smtp.host,
-		cfg.smtp.port,
-		cfg.smtp.username,
-		cfg.smtp.password,
-		sender,
-		ui.Files,
-		"mail/*.tmpl",
-	)
+	transport, err := newMailTransport(cfg, logger)
+	if err != nil {
+		logger.Error("unable to create mail transport", slog.Any("err", err))
+		os.Exit(1)
+	}
+	// Mail is stored in the outbox and sent by the queue workers
+	mailQueue := newMailQueue(models.MailOutbox, metrics.instrumentTransport(transport), logger)
+	var mt mailer.Transport = mailQueue
+
+	// Sign mail before it is queued
+	if cfg.dkim.keyFile != "" {
+		mt, err = newDKIMTransport(cfg, mt)
+		if err != nil {
+			logger.Error("unable to configure dkim", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	// Capture mail for the dev mail catcher
+	var mailbox *mailer.Mailbox
+	if cfg.dev {
+		mailbox = mailer.NewMailbox(mt, 100)
+		mt = mailbox
+	}
+
+	mailer, err := mailer.New(mt, sender, baseURL, ui.Files, "mail/base.tmpl", "mail/messages/*.tmpl")
 	if err != nil {
 		logger.Error("unable to create mailer", slog.Any("err", err))
 		os.Exit(1)
 	}
 
 	// Session manager
-	sm := scs.New()
-	sm.Store = pgxstore.New(pool)
-	sm.Lifetime = 12 * time.Hour
-	gob.Register(uuid.UUID{})
-	gob.Register(FlashMessage{})
-	gob.Register(FormErrors{})
+	sm := newSessionManager(pgxstore.New(pool))
 
 	// Template cache
 	tc, err := newTemplateCache()
@@ -132,29 +167,88 @@ func main() {
 		config:         cfg,
 		logger:         logger,
 		mailer:         mailer,
-		models:         models.New(pool),
+		mailQueue:      mailQueue,
+		mailbox:        mailbox,
+		metrics:        metrics,
+		models:         models,
+		oidc:           op,
 		sessionManager: sm,
 		templateCache:  tc,
 		formDecoder:    form.NewDecoder(),
 		validate:       validator.New(),
+		webauthn:       wa,
+		shutdown:       make(chan struct{}),
 	}
 
+	mailQueue.Start(cfg.mail.workers)
+
+	// Delete accounts whose grace period has ended
+	app.background(func() {
+		app.purgeDeletedUsers(time.Hour)
+	})
+
+	// Remove failed mail, which may contain tokens
+	app.background(func() {
+		app.purgeFailedMail(time.Hour)
+	})
+
+	// Remove expired sessions from the index of user sessions
+	app.background(func() {
+		app.purgeExpiredSessions(10 * time.Minute)
+	})
+
 	srv := &http.Server{
 		Addr:     fmt.Sprintf(":%d", cfg.port),
 		Handler:  app.routes(),
 		ErrorLog: errLog,
 	}
 
-	logger.Info("starting server", "addr", srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Error(err.Error())
+	// Metrics are served on a separate port, which should not be public
+	var metricsSrv *http.Server
+	if cfg.metricsPort != 0 {
+		metricsSrv = &http.Server{
+			Addr:     fmt.Sprintf(":%d", cfg.metricsPort),
+			Handler:  metrics.handler(),
+			ErrorLog: errLog,
+		}
+	}
+
+	err = app.serve(srv, metricsSrv)
+	if err != nil {
+		logger.Error("server", slog.Any("err", err))
+	}
+
+	pool.Close()
+
+	// Flush remaining spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tracingErr := shutdownTracing(ctx)
+	if tracingErr != nil {
+		logger.Error("tracing shutdown", slog.Any("err", tracingErr))
+	}
+
+	logger.Info("stopped server")
+
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func openPool(cfg config) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	dbpool, err := pgxpool.New(ctx, cfg.db.dsn)
+	poolCfg, err := pgxpool.ParseConfig(cfg.db.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries made within a trace get a span
+	poolCfg.ConnConfig.Tracer = newQueryTracer()
+
+	dbpool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -167,6 +261,46 @@ func openPool(cfg config) (*pgxpool.Pool, error) {
 	return dbpool, err
 }
 
+func newSessionManager(store scs.Store) *scs.SessionManager {
+	sm := scs.New()
+	sm.Store = store
+	sm.Lifetime = 12 * time.Hour
+
+	// Types stored in session data
+	gob.Register(uuid.UUID{})
+	gob.Register(FlashMessage{})
+	gob.Register(FormErrors{})
+
+	return sm
+}
+
+func newMailTransport(cfg config, logger *slog.Logger) (mailer.Transport, error) {
+	switch cfg.mail.transport {
+	case "smtp":
+		return mailer.NewSMTPTransport(
+			cfg.smtp.host,
+			cfg.smtp.port,
+			cfg.smtp.username,
+			cfg.smtp.password,
+		), nil
+	case "file":
+		return mailer.NewFileTransport(cfg.mail.dir)
+	case "log":
+		return mailer.NewLogTransport(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.mail.transport)
+	}
+}
+
+func newDKIMTransport(cfg config, next mailer.Transport) (mailer.Transport, error) {
+	key, err := mailer.LoadDKIMKey(cfg.dkim.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return mailer.NewDKIMTransport(next, cfg.dkim.domain, cfg.dkim.selector, key)
+}
+
 func newSlogHandler(cfg config) slog.Handler {
 	if cfg.dev {
 		// Development text hanlder
@@ -180,14 +314,3 @@ func newSlogHandler(cfg config) slog.Handler {
 	// Production use JSON handler with default opts
 	return slog.NewJSONHandler(os.Stdout, nil)
 }
-
-func (app *application) background(fn func()) {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Error("background", slog.Any("err", err))
-			}
-		}()
-		fn()
-	}()
-}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/micahco/web/internal/mailer"
+	"github.com/micahco/web/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	mailSent        *prometheus.CounterVec
+}
+
+func newMetrics(pool *pgxpool.Pool, sessions models.SessionStore, logger *slog.Logger) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		mailSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mail_send_total",
+			Help: "Attempts to send mail with the transport by result.",
+		}, []string{"result"}),
+	}
+
+	// Session counts are queried on each scrape. Gauge functions get no
+	// context, so the query is only bounded by the model timeout.
+	sessionCount := func(authenticated bool) func() float64 {
+		return func() float64 {
+			total, auth, err := sessions.Count(context.Background())
+			if err != nil {
+				logger.Error("metrics session count", slog.Any("err", err))
+
+				return 0
+			}
+
+			if authenticated {
+				return float64(auth)
+			}
+
+			return float64(total)
+		}
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newPoolCollector(pool),
+		m.requests,
+		m.requestDuration,
+		m.mailSent,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sessions_active",
+			Help: "Unexpired sessions in the session store.",
+		}, sessionCount(false)),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sessions_authenticated",
+			Help: "Unexpired sessions of authenticated users.",
+		}, sessionCount(true)),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Wraps transport to count sent and failed messages
+func (m *metrics) instrumentTransport(next mailer.Transport) mailer.Transport {
+	return &instrumentedTransport{next, m.mailSent}
+}
+
+type instrumentedTransport struct {
+	next    mailer.Transport
+	counter *prometheus.CounterVec
+}
+
+func (t *instrumentedTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	err := t.next.Send(ctx, from, to, msg)
+	if err != nil {
+		t.counter.WithLabelValues("failure").Inc()
+
+		return err
+	}
+
+	t.counter.WithLabelValues("success").Inc()
+
+	return nil
+}
+
+func (t *instrumentedTransport) Ping(ctx context.Context) error {
+	if p, ok := t.next.(mailer.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// Exports pgxpool statistics
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	acquires     *prometheus.Desc
+	acquireWait  *prometheus.Desc
+	emptyWaits   *prometheus.Desc
+	canceled     *prometheus.Desc
+	newConns     *prometheus.Desc
+	idleDestroys *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("pgxpool_"+name, help, nil, nil)
+	}
+
+	return &poolCollector{
+		pool:         pool,
+		acquired:     desc("acquired_conns", "Connections currently acquired."),
+		idle:         desc("idle_conns", "Connections currently idle."),
+		total:        desc("total_conns", "Connections currently open."),
+		max:          desc("max_conns", "Maximum size of the pool."),
+		acquires:     desc("acquires_total", "Successful acquires from the pool."),
+		acquireWait:  desc("acquire_duration_seconds_total", "Time spent acquiring connections."),
+		emptyWaits:   desc("empty_acquires_total", "Acquires that waited for a connection because the pool was empty."),
+		canceled:     desc("canceled_acquires_total", "Acquires canceled by their context."),
+		newConns:     desc("new_conns_total", "Connections opened."),
+		idleDestroys: desc("idle_destroys_total", "Connections closed for being idle too long."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	gauge(c.acquired, float64(s.AcquiredConns()))
+	gauge(c.idle, float64(s.IdleConns()))
+	gauge(c.total, float64(s.TotalConns()))
+	gauge(c.max, float64(s.MaxConns()))
+	counter(c.acquires, float64(s.AcquireCount()))
+	counter(c.acquireWait, s.AcquireDuration().Seconds())
+	counter(c.emptyWaits, float64(s.EmptyAcquireCount()))
+	counter(c.canceled, float64(s.CanceledAcquireCount()))
+	counter(c.newConns, float64(s.NewConnsCount()))
+	counter(c.idleDestroys, float64(s.MaxIdleDestroyCount()))
+}
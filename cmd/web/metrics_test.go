@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func newTestMetrics(t *testing.T, app *application) *metrics {
+	t.Helper()
+
+	// The pool only connects when used, so its statistics can be
+	// collected without a database.
+	pool, err := pgxpool.New(context.Background(), "postgres://127.0.0.1:1/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return newMetrics(pool, app.models.Session, app.logger)
+}
+
+// Scrape metrics in the text exposition format.
+func scrape(t *testing.T, m *metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rec.Code, http.StatusOK)
+	}
+
+	return rec.Body.String()
+}
+
+func assertMetric(t *testing.T, body, sample string) {
+	t.Helper()
+
+	for _, line := range strings.Split(body, "\n") {
+		if line == sample {
+			return
+		}
+	}
+
+	t.Fatalf("no sample %q in:\n%s", sample, body)
+}
+
+func TestMetricsRoutes(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.metrics = newTestMetrics(t, app)
+	ts := newTestServer(t, app.routes())
+
+	ids := make([]string, 2)
+	for i := range ids {
+		id, err := uuid.NewV4()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id.String()
+
+		res := ts.postForm(t, "/account/sessions/"+ids[i]+"/revoke", nil)
+		assertRedirect(t, res, "/auth/login")
+	}
+
+	res := ts.get(t, "/not/a/route")
+	assertStatus(t, res, http.StatusNotFound)
+
+	body := scrape(t, app.metrics)
+
+	// Requests are counted by route pattern, so paths with IDs share a
+	// series
+	assertMetric(t, body, `http_requests_total{method="POST",route="/account/sessions/{id}/revoke",status="303"} 2`)
+	assertMetric(t, body, `http_request_duration_seconds_count{method="POST",route="/account/sessions/{id}/revoke"} 2`)
+	// Unknown paths are counted under the catch-all pattern
+	assertMetric(t, body, `http_requests_total{method="GET",route="/*",status="404"} 1`)
+
+	for _, raw := range append(ids, "/not/a/route") {
+		if strings.Contains(body, raw) {
+			t.Fatalf("raw path %q in metrics", raw)
+		}
+	}
+}
+
+func TestMetricsMailSend(t *testing.T) {
+	app, _ := newTestApplication(t)
+	m := newTestMetrics(t, app)
+	tr := m.instrumentTransport(&flakyTransport{failures: 1})
+
+	for i := range 3 {
+		err := tr.Send(context.Background(), "noreply@example.com", []string{testEmail}, []byte(testQueueMessage))
+		if (err != nil) != (i == 0) {
+			t.Fatalf("send %d: got %v; want only the first to fail", i+1, err)
+		}
+	}
+
+	body := scrape(t, m)
+	assertMetric(t, body, `mail_send_total{result="failure"} 1`)
+	assertMetric(t, body, `mail_send_total{result="success"} 2`)
+}
+
+func TestMetricsRegistered(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.metrics = newTestMetrics(t, app)
+	ts := newTestServer(t, app.routes())
+
+	body := scrape(t, app.metrics)
+	assertMetric(t, body, "sessions_active 0")
+	assertMetric(t, body, "sessions_authenticated 0")
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	body = scrape(t, app.metrics)
+	assertMetric(t, body, "sessions_active 1")
+	assertMetric(t, body, "sessions_authenticated 1")
+
+	for _, name := range []string{"go_goroutines", "process_", "pgxpool_max_conns"} {
+		if !strings.Contains(body, "\n"+name) {
+			t.Errorf("no %s metrics", name)
+		}
+	}
+}
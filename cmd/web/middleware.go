@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/gofrs/uuid/v5"
 	"github.com/justinas/nosurf"
+	"github.com/micahco/web/internal/models"
 )
 
 func (app *application) recovery(next http.Handler) http.Handler {
@@ -15,7 +22,7 @@ func (app *application) recovery(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
 
-				app.logger.Error("recovered from panic", slog.Any("err", err))
+				app.requestLogger(r).ErrorContext(r.Context(), "recovered from panic", slog.Any("err", err))
 
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
@@ -53,7 +60,7 @@ func (app *application) noSurf(next http.Handler) http.Handler {
 
 func (app *application) csrfFailureHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.logger.Error("csrf failure handler",
+		app.requestLogger(r).ErrorContext(r.Context(), "csrf failure handler",
 			slog.String("method", r.Method),
 			slog.String("uri", r.URL.RequestURI()),
 		)
@@ -62,8 +69,9 @@ func (app *application) csrfFailureHandler() http.Handler {
 	})
 }
 
-// Reads session authenticated user id key and checks if that user exists.
-// If all systems check, then set authenticated context to the request.
+// Reads session authenticated user id key and checks if that user exists
+// and is not disabled. If all systems check, then set authenticated context
+// and the user's roles to the request.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, ok := app.sessionManager.Get(r.Context(), authenticatedUserIDSessionKey).(uuid.UUID)
@@ -73,16 +81,38 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := app.models.User.Exists(id)
-		if err != nil {
+		serverError := func(err error) {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			app.logger.Error("middleware authenticate", slog.Any("err", err))
+			app.requestLogger(r).ErrorContext(r.Context(), "middleware authenticate", slog.Any("err", err))
+		}
+
+		user, err := app.models.User.GetWithID(r.Context(), id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			serverError(err)
 
 			return
 		}
 
-		if exists {
+		if user != nil && !user.IsDisabled() {
+			token := app.sessionManager.Token(r.Context())
+			err = app.models.Session.Touch(r.Context(), token, clientIP(r), userAgent(r))
+			if err != nil {
+				serverError(err)
+
+				return
+			}
+
+			roles, err := app.models.Role.GetAllForUser(r.Context(), user.ID)
+			if err != nil {
+				serverError(err)
+
+				return
+			}
+
+			setRequestUser(r, user.ID)
+
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, rolesContextKey, roles)
 			r = r.WithContext(ctx)
 		}
 
@@ -103,3 +133,188 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Require any role of the authenticated user to grant permission.
+func (app *application) requirePermission(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return app.requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.roles(r).Can(p) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// Records the status code and number of body bytes written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Record request count and latency by the chi route pattern, which is
+// only known once the request has been routed.
+func (app *application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		route := routePattern(r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		app.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		app.metrics.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Matched chi route pattern of a request that has been routed.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+
+	return "unmatched"
+}
+
+// Maximum length of a request ID accepted from the X-Request-ID header.
+const maxRequestIDLength = 128
+
+// Values of a request that are shared between middleware. Stored in the
+// context as a pointer, so the access log sees the user set by inner
+// middleware.
+type requestInfo struct {
+	id     string
+	logger *slog.Logger
+	userID uuid.NullUUID
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+
+	return info
+}
+
+// Logger of the request, which adds its request ID to records.
+func (app *application) requestLogger(r *http.Request) *slog.Logger {
+	if info := getRequestInfo(r); info != nil {
+		return info.logger
+	}
+
+	return app.logger
+}
+
+// Record the authenticated user of the request for the access log.
+func setRequestUser(r *http.Request, id uuid.UUID) {
+	if info := getRequestInfo(r); info != nil {
+		info.userID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+}
+
+// Use the request ID set by a proxy in the X-Request-ID header, or
+// generate one, and add a logger carrying it to the request context.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.logger.Error("request id", slog.Any("err", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{
+			id:     id,
+			logger: app.logger.With(slog.String("request_id", id)),
+		}
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Log one line per request once it has been handled.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", routePattern(r)),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", clientIP(r)),
+		}
+
+		if info := getRequestInfo(r); info != nil && info.userID.Valid {
+			attrs = append(attrs, slog.String("user_id", info.userID.UUID.String()))
+		}
+
+		app.requestLogger(r).LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
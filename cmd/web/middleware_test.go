@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Access log record of a request
+type accessLog struct {
+	Msg       string `json:"msg"`
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Route     string `json:"route"`
+	Status    int    `json:"status"`
+	UserID    string `json:"user_id"`
+}
+
+// Get the last access log record in JSON logs.
+func lastAccessLog(t *testing.T, logs *bytes.Buffer) accessLog {
+	t.Helper()
+
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	line := lines[len(lines)-1]
+
+	var record accessLog
+	err := json.Unmarshal(line, &record)
+	if err != nil {
+		t.Fatalf("decode log %q: %v", line, err)
+	}
+
+	if record.Msg != "request" {
+		t.Fatalf("got log %s; want request record", line)
+	}
+
+	return record
+}
+
+var generatedRequestIDRX = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		// Empty if a new ID is generated
+		want string
+	}{
+		{"missing", "", ""},
+		{"valid", "proxy-id_1.2:3", "proxy-id_1.2:3"},
+		{"invalid characters", "bad id<script>", ""},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), ""},
+		{"max length", strings.Repeat("a", maxRequestIDLength), strings.Repeat("a", maxRequestIDLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			app, _ := newTestApplication(t)
+			app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+			ts := newTestServer(t, app.routes())
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/healthz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+
+			res := ts.do(t, req)
+			assertStatus(t, res, http.StatusOK)
+
+			id := res.header.Get("X-Request-ID")
+			if tt.want != "" && id != tt.want {
+				t.Fatalf("got request id %q; want %q", id, tt.want)
+			}
+			if tt.want == "" && !generatedRequestIDRX.MatchString(id) {
+				t.Fatalf("got request id %q; want generated id", id)
+			}
+
+			record := lastAccessLog(t, &logs)
+			if record.RequestID != id || record.Status != http.StatusOK || record.Route != "/healthz" {
+				t.Fatalf("got log %+v; want request %s with status %d", record, id, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRequestIDsDiffer(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	a := ts.get(t, "/healthz").header.Get("X-Request-ID")
+	b := ts.get(t, "/healthz").header.Get("X-Request-ID")
+	if a == b {
+		t.Fatalf("got request id %q twice", a)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	app, _ := newTestApplication(t)
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ts.get(t, "/account/sessions")
+	assertRedirect(t, res, "/auth/login")
+
+	record := lastAccessLog(t, &logs)
+	if record.Status != http.StatusSeeOther || record.UserID != "" || record.RequestID != res.header.Get("X-Request-ID") {
+		t.Fatalf("got log %+v; want anonymous redirect", record)
+	}
+
+	res = login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	res = ts.get(t, "/account/sessions")
+	assertStatus(t, res, http.StatusOK)
+
+	record = lastAccessLog(t, &logs)
+	if record.Method != http.MethodGet || record.Route != "/account/sessions" || record.Status != http.StatusOK {
+		t.Fatalf("got log %+v; want GET /account/sessions with status %d", record, http.StatusOK)
+	}
+	if record.UserID != user.ID.String() || record.RequestID != res.header.Get("X-Request-ID") {
+		t.Fatalf("got log %+v; want user %s and request id %s", record, user.ID, res.header.Get("X-Request-ID"))
+	}
+}
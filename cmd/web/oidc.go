@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+	"golang.org/x/oauth2"
+)
+
+// OpenID Connect provider used for social login
+type oidcProvider struct {
+	name     string
+	issuer   string
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Discover provider configuration from the issuer.
+func newOIDCProvider(cfg config, baseURL *url.URL) (*oidcProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, cfg.oidc.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &url.URL{Path: "/auth/oidc/callback"}
+
+	return &oidcProvider{
+		name:   cfg.oidc.name,
+		issuer: cfg.oidc.issuer,
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  baseURL.ResolveReference(ref).String(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.oidc.clientID}),
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (app *application) handleAuthOIDCLoginPost(w http.ResponseWriter, r *http.Request) error {
+	if app.oidc == nil {
+		return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	if app.isAuthenticated(r) {
+		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), oidcStateSessionKey, state)
+	app.sessionManager.Put(r.Context(), oidcNonceSessionKey, nonce)
+	app.sessionManager.Put(r.Context(), oidcVerifierSessionKey, verifier)
+
+	u := app.oidc.oauth2.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	)
+	http.Redirect(w, r, u, http.StatusSeeOther)
+
+	return nil
+}
+
+var oidcFailedFlash = FlashMessage{
+	Type:    FlashError,
+	Message: "Unable to sign in with your provider account.",
+}
+
+// Send the user back to the login form with a flash message. The reason
+// is only logged, since it's rarely something the user can act on.
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, reason string, err error) error {
+	app.requestLogger(r).DebugContext(r.Context(), "oidc login failed", slog.String("reason", reason), slog.Any("err", err))
+
+	// The user is not known until the ID token is verified
+	err = app.audit(r, models.AuditLogin, models.AuditFailure, uuid.Nil, "")
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, oidcFailedFlash)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) handleAuthOIDCCallbackGet(w http.ResponseWriter, r *http.Request) error {
+	if app.oidc == nil {
+		return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	state := app.sessionManager.PopString(r.Context(), oidcStateSessionKey)
+	nonce := app.sessionManager.PopString(r.Context(), oidcNonceSessionKey)
+	verifier := app.sessionManager.PopString(r.Context(), oidcVerifierSessionKey)
+
+	q := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		return app.oidcFailed(w, r, "invalid state", nil)
+	}
+
+	// Provider denied the request, e.g. the user cancelled
+	if e := q.Get("error"); e != "" {
+		return app.oidcFailed(w, r, "error response", errors.New(e))
+	}
+
+	// Fails for bad or expired codes, e.g. when the callback is reloaded
+	token, err := app.oidc.oauth2.Exchange(r.Context(), q.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return app.oidcFailed(w, r, "code exchange failed", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return app.oidcFailed(w, r, "missing id token", nil)
+	}
+
+	idToken, err := app.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return app.oidcFailed(w, r, "invalid id token", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(idToken.Nonce)) != 1 {
+		return app.oidcFailed(w, r, "invalid nonce", nil)
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.userForIdentity(r.Context(), idToken.Subject, claims.Email, claims.EmailVerified)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			app.putFlash(r, FlashMessage{
+				Type:    FlashError,
+				Message: "Your provider account does not have a verified email address.",
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+
+			return nil
+		}
+
+		return err
+	}
+
+	return app.loginWithSecondFactor(w, r, user)
+}
+
+var errUnverifiedEmail = errors.New("oidc: unverified email")
+
+// Get the user linked to the external identity. Unknown identities are
+// linked to the user with the same verified email, which is created if
+// it does not exist.
+func (app *application) userForIdentity(ctx context.Context, subject, email string, emailVerified bool) (*models.User, error) {
+	identity, err := app.models.Identity.Get(ctx, app.oidc.issuer, subject)
+	if err == nil {
+		return app.models.User.GetWithID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	if email == "" || !emailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := app.models.User.GetWithEmail(ctx, email)
+	if errors.Is(err, models.ErrNoRecord) {
+		user, err = app.models.User.New(ctx, email, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity = &models.Identity{
+		Issuer:  app.oidc.issuer,
+		Subject: subject,
+		UserID:  user.ID,
+		Email:   email,
+	}
+
+	err = app.models.Identity.Insert(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micahco/web/internal/models"
+)
+
+const (
+	testOIDCClientID = "web"
+	testOIDCSubject  = "alice-subject"
+)
+
+// Stand-in OpenID Connect provider. Codes are issued by authorize, which
+// the test calls in place of the user agent following the redirect.
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// Authorization requests by code
+	codes map[string]url.Values
+	// Token responses leave out the ID token, like a plain OAuth 2.0
+	// server
+	omitIDToken bool
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &testIssuer{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.handleDiscovery)
+	mux.HandleFunc("GET /keys", iss.handleKeys)
+	mux.HandleFunc("POST /token", iss.handleToken)
+
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+
+	return iss
+}
+
+func (iss *testIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss.URL,
+		"authorization_endpoint":                iss.URL + "/authorize",
+		"token_endpoint":                        iss.URL + "/token",
+		"jwks_uri":                              iss.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (iss *testIssuer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   b64.EncodeToString(pub.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// Issue a code for the authorization request that the app redirected to.
+func (iss *testIssuer) authorize(t *testing.T, location string) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != testOIDCClientID || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+
+	code, err = randomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss.mu.Lock()
+	iss.codes[code] = q
+	iss.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (iss *testIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	auth, ok := iss.codes[r.PostFormValue("code")]
+	delete(iss.codes, r.PostFormValue("code"))
+	omitIDToken := iss.omitIDToken
+	iss.mu.Unlock()
+
+	// Codes are single use and bound to the PKCE verifier
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || b64.EncodeToString(challenge[:]) != auth.Get("code_challenge") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if omitIDToken {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+
+	idToken, err := iss.sign(map[string]any{
+		"iss":            iss.URL,
+		"sub":            testOIDCSubject,
+		"aud":            testOIDCClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          auth.Get("nonce"),
+		"email":          testEmail,
+		"email_verified": true,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// Sign claims as a compact RS256 JWT.
+func (iss *testIssuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + b64.EncodeToString(sig), nil
+}
+
+func newOIDCTestServer(t *testing.T) (*application, *testServer, *testIssuer) {
+	t.Helper()
+
+	iss := newTestIssuer(t)
+
+	app, _ := newTestApplication(t)
+
+	var cfg config
+	cfg.oidc.issuer = iss.URL
+	cfg.oidc.clientID = testOIDCClientID
+	cfg.oidc.clientSecret = "secret"
+
+	op, err := newOIDCProvider(cfg, app.baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.oidc = op
+
+	return app, newTestServer(t, app.routes()), iss
+}
+
+func TestOIDCLogin(t *testing.T) {
+	app, ts, iss := newOIDCTestServer(t)
+
+	res := ts.postForm(t, "/auth/oidc/login", nil)
+	assertStatus(t, res, http.StatusSeeOther)
+
+	code, state := iss.authorize(t, res.header.Get("Location"))
+
+	res = ts.get(t, "/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode())
+	assertRedirect(t, res, "/")
+	assertAuthenticated(t, ts, true)
+
+	// The identity is linked to a new user with the verified email
+	user, err := app.models.User.GetWithEmail(context.Background(), testEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := app.models.Identity.Get(context.Background(), iss.URL, testOIDCSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.UserID != user.ID {
+		t.Fatalf("got identity of user %s; want %s", identity.UserID, user.ID)
+	}
+}
+
+func TestOIDCLoginBadCode(t *testing.T) {
+	app, ts, iss := newOIDCTestServer(t)
+
+	res := ts.postForm(t, "/auth/oidc/login", nil)
+	assertStatus(t, res, http.StatusSeeOther)
+
+	_, state := iss.authorize(t, res.header.Get("Location"))
+
+	res = ts.get(t, "/auth/oidc/callback?"+url.Values{"code": {"expired"}, "state": {state}}.Encode())
+	assertRedirect(t, res, "/")
+	assertAuditEvent(t, app, models.AuditLogin, models.AuditFailure)
+	assertAuthenticated(t, ts, false)
+}
+
+func TestOIDCLoginMissingIDToken(t *testing.T) {
+	app, ts, iss := newOIDCTestServer(t)
+
+	iss.mu.Lock()
+	iss.omitIDToken = true
+	iss.mu.Unlock()
+
+	res := ts.postForm(t, "/auth/oidc/login", nil)
+	assertStatus(t, res, http.StatusSeeOther)
+
+	code, state := iss.authorize(t, res.header.Get("Location"))
+
+	res = ts.get(t, "/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode())
+	assertRedirect(t, res, "/")
+	assertAuditEvent(t, app, models.AuditLogin, models.AuditFailure)
+	assertAuthenticated(t, ts, false)
+}
@@ -8,6 +8,8 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
 	"github.com/micahco/web/ui"
 )
 
@@ -25,7 +27,7 @@ func (app *application) handle(h withError) http.HandlerFunc {
 				http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
 			default:
 				// Log unexpected error and render internal server error
-				app.logger.Error("handled unexpected error", slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
+				app.requestLogger(r).ErrorContext(r.Context(), "handled unexpected error", slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
 
 				app.renderError(w, r, http.StatusInternalServerError, "")
 			}
@@ -36,6 +38,12 @@ func (app *application) handle(h withError) http.HandlerFunc {
 // App router
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(app.requestID)
+	r.Use(app.trace)
+	r.Use(app.logRequests)
+	if app.metrics != nil {
+		r.Use(app.instrument)
+	}
 	r.Use(app.recovery)
 	r.Use(secureHeaders)
 
@@ -43,6 +51,14 @@ func (app *application) routes() http.Handler {
 	r.Handle("/static/*", app.handleStatic())
 	r.Get("/favicon.ico", app.handleFavicon)
 
+	// Probes for orchestrators, without sessions
+	r.Get("/healthz", app.handleAPI(app.handleHealthzGet))
+	r.Get("/readyz", app.handleAPI(app.handleReadyzGet))
+	r.Get("/version", app.handleAPI(app.handleVersionGet))
+
+	// JSON API authenticates with bearer tokens instead of sessions
+	r.Route("/api/v1", app.apiRoutes)
+
 	r.Route("/", func(r chi.Router) {
 		r.Use(app.sessionManager.LoadAndSave)
 		r.Use(app.noSurf)
@@ -60,8 +76,101 @@ func (app *application) routes() http.Handler {
 			r.Post("/reset", app.handle(app.handleAuthResetPost))
 			r.Get("/reset/update", app.handle(app.handleAuthResetUpdateGet))
 			r.Post("/reset/update", app.handle(app.handleAuthResetUpdatePost))
+
+			r.Route("/2fa", func(r chi.Router) {
+				r.Get("/verify", app.handle(app.handleAuthTwoFactorVerifyGet))
+				r.Post("/verify", app.handle(app.handleAuthTwoFactorVerifyPost))
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireAuthentication)
+
+					r.Get("/", app.handle(app.handleAuthTwoFactorGet))
+					r.Get("/qr.png", app.handle(app.handleAuthTwoFactorQRGet))
+					r.Post("/enable", app.handle(app.handleAuthTwoFactorEnablePost))
+					r.Post("/disable", app.handle(app.handleAuthTwoFactorDisablePost))
+					r.Post("/recovery", app.handle(app.handleAuthTwoFactorRecoveryPost))
+				})
+			})
+
+			r.Get("/magic", app.handle(app.handleAuthMagicGet))
+			r.Post("/magic", app.handle(app.handleAuthMagicPost))
+			r.Post("/magic/login", app.handle(app.handleAuthMagicLoginPost))
+
+			r.Post("/oidc/login", app.handle(app.handleAuthOIDCLoginPost))
+			r.Get("/oidc/callback", app.handle(app.handleAuthOIDCCallbackGet))
+
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Post("/login/begin", app.handle(app.handleWebAuthnLoginBeginPost))
+				r.Post("/login/finish", app.handle(app.handleWebAuthnLoginFinishPost))
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireAuthentication)
+
+					r.Post("/register/begin", app.handle(app.handleWebAuthnRegisterBeginPost))
+					r.Post("/register/finish", app.handle(app.handleWebAuthnRegisterFinishPost))
+					r.Post("/credentials/{id}/delete", app.handle(app.handleWebAuthnCredentialDeletePost))
+				})
+			})
+		})
+
+		r.Route("/account", func(r chi.Router) {
+			// Links sent by email work without being logged in
+			r.Get("/email/confirm", app.handle(app.handleAccountEmailConfirmGet))
+			r.Post("/email/confirm", app.handle(app.handleAccountEmailConfirmPost))
+			r.Get("/email/cancel", app.handle(app.handleAccountEmailCancelGet))
+			r.Post("/email/cancel", app.handle(app.handleAccountEmailCancelPost))
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireAuthentication)
+
+				r.Get("/email", app.handle(app.handleAccountEmailGet))
+				r.Post("/email", app.handle(app.handleAccountEmailPost))
+
+				r.Get("/delete", app.handle(app.handleAccountDeleteGet))
+				r.Post("/delete", app.handle(app.handleAccountDeletePost))
+
+				r.Get("/sessions", app.handle(app.handleAccountSessionsGet))
+				r.Post("/sessions/{id}/revoke", app.handle(app.handleAccountSessionRevokePost))
+				r.Post("/sessions/revoke-others", app.handle(app.handleAccountSessionsRevokeOthersPost))
+
+				r.Post("/tokens", app.handle(app.handleAccountTokensPost))
+				r.Post("/tokens/{id}/revoke", app.handle(app.handleAccountTokenRevokePost))
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.requirePermission(models.PermissionViewUsers))
+
+			r.Get("/", app.handle(app.handleAdminUsersGet))
+			r.Get("/users/{id}", app.handle(app.handleAdminUserGet))
+			r.Get("/audit", app.handle(app.handleAdminAuditGet))
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission(models.PermissionDisableUsers))
+
+				r.Post("/users/{id}/disable", app.handle(app.handleAdminUserDisablePost))
+				r.Post("/users/{id}/enable", app.handle(app.handleAdminUserEnablePost))
+				r.Post("/users/{id}/unlock", app.handle(app.handleAdminUserUnlockPost))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission(models.PermissionManageMail))
+
+				r.Get("/mail", app.handle(app.handleAdminMailGet))
+				r.Post("/mail/{id}/retry", app.handle(app.handleAdminMailRetryPost))
+				r.Post("/mail/{id}/delete", app.handle(app.handleAdminMailDeletePost))
+			})
 		})
 
+		// Mail catcher, only in dev mode
+		if app.mailbox != nil {
+			r.Route("/dev/mail", func(r chi.Router) {
+				r.Get("/", app.handle(app.handleDevMailGet))
+				r.Get("/{id}", app.handle(app.handleDevMailMessageGet))
+				r.Get("/{id}/html", app.handle(app.handleDevMailHTMLGet))
+			})
+		}
+
 		r.Route("/articles", func(r chi.Router) {
 			r.Use(app.requireAuthentication)
 
@@ -96,7 +205,11 @@ func (app *application) handleFavicon(w http.ResponseWriter, r *http.Request) {
 }
 
 type userData struct {
-	Email string
+	Email       string
+	Credentials []*models.Credential
+	APITokens   []*models.APIToken
+	ExpiryDays  []int
+	AuditEvents []*models.AuditEvent
 }
 
 func (app *application) getIndex(w http.ResponseWriter, r *http.Request) error {
@@ -106,15 +219,39 @@ func (app *application) getIndex(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		u, err := app.models.User.GetWithID(suid)
+		u, err := app.models.User.GetWithID(r.Context(), suid)
 		if err != nil {
 			return err
 		}
 
-		return app.render(w, r, http.StatusOK, "dashboard.tmpl", userData{u.Email})
+		creds, err := app.models.Credential.GetAllForUser(r.Context(), u.ID)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := app.models.APIToken.GetAllForUser(r.Context(), u.ID)
+		if err != nil {
+			return err
+		}
+
+		// Recent security events of the user
+		filter := models.AuditFilter{UserID: uuid.NullUUID{UUID: u.ID, Valid: true}}
+		events, err := app.models.AuditEvent.GetAll(r.Context(), filter, 10, 0)
+		if err != nil {
+			return err
+		}
+
+		return app.render(w, r, http.StatusOK, "dashboard.tmpl", userData{u.Email, creds, tokens, apiTokenExpiryDays, events})
+	}
+
+	var data struct {
+		OIDCName string
+	}
+	if app.oidc != nil {
+		data.OIDCName = app.oidc.name
 	}
 
-	return app.render(w, r, http.StatusOK, "login.tmpl", nil)
+	return app.render(w, r, http.StatusOK, "login.tmpl", data)
 }
 
 func (app *application) getArticleID(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Serve until SIGINT or SIGTERM, or until a server fails, then drain.
+// The admin server is optional.
+func (app *application) serve(srv, admin *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		app.logger.Info("starting server", slog.String("addr", srv.Addr))
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	adminErr := make(chan error, 1)
+	if admin != nil {
+		go func() {
+			app.logger.Info("starting admin server", slog.String("addr", admin.Addr))
+			adminErr <- admin.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case err = <-adminErr:
+	case <-ctx.Done():
+		// Restore default behavior, so a second signal exits immediately
+		stop()
+	}
+
+	return errors.Join(err, app.drain(srv, admin))
+}
+
+// Shut down in order: stop accepting requests and wait for in-flight
+// ones, stop background tasks, send queued mail, and stop the admin
+// server last so metrics can be scraped while draining. Requests get the
+// shutdown timeout, and the remaining steps get it again, so they still
+// run if requests didn't finish in time. Nothing uses the database once
+// drain returns.
+func (app *application) drain(srv, admin *http.Server) error {
+	app.logger.Info("shutting down server", slog.Duration("timeout", app.config.shutdownTimeout))
+
+	var errs []error
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		app.logger.Error("server shutdown", slog.Any("err", err))
+		errs = append(errs, fmt.Errorf("server shutdown: %w", err))
+
+		// Drop requests that are still in flight
+		err = srv.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	app.logger.Info("stopping background tasks")
+	close(app.shutdown)
+
+	err = wait(ctx, &app.wg)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("background tasks: %w", err))
+	}
+
+	app.logger.Info("draining mail queue")
+
+	err = app.mailQueue.Shutdown(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("mail queue: %w", err))
+	}
+
+	if admin != nil {
+		err = admin.Shutdown(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("admin server shutdown: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run fn in a goroutine that is waited for on shutdown. Long running
+// tasks must return once app.shutdown is closed.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background", slog.Any("err", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// Context of background tasks, cancelled once app.shutdown is closed so
+// that draining doesn't wait on their queries.
+func (app *application) shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-app.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// Wait for wg, or until ctx is done.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
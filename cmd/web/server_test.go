@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name string
+		// Time the in-flight request takes after shutdown starts
+		delay   time.Duration
+		wantErr error
+	}{
+		{"request finishes", 10 * time.Millisecond, nil},
+		{"request times out", time.Second, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			app.config.shutdownTimeout = 100 * time.Millisecond
+			app.mailQueue = newMailQueue(nil, nil, app.logger)
+
+			started := make(chan struct{})
+			srv := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					time.Sleep(tt.delay)
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(ln)
+
+			status := make(chan int, 1)
+			go func() {
+				res, err := http.Get("http://" + ln.Addr().String())
+				if err != nil {
+					status <- 0
+					return
+				}
+				res.Body.Close()
+				status <- res.StatusCode
+			}()
+
+			// Background task that only stops on shutdown
+			stopped := make(chan struct{})
+			app.background(func() {
+				<-app.shutdown
+				close(stopped)
+			})
+
+			<-started
+
+			err = app.drain(srv, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			// Background tasks and the mail queue are stopped either way
+			select {
+			case <-stopped:
+			default:
+				t.Fatal("background task is still running")
+			}
+
+			select {
+			case <-app.mailQueue.quit:
+			default:
+				t.Fatal("mail queue is still running")
+			}
+
+			if tt.wantErr == nil {
+				if got := <-status; got != http.StatusOK {
+					t.Fatalf("got status %d; want %d", got, http.StatusOK)
+				}
+			}
+		})
+	}
+}
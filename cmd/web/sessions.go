@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+func (app *application) handleAccountSessionsGet(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := app.models.Session.GetAllForUser(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	type sessionData struct {
+		*models.Session
+		Current bool
+	}
+
+	token := app.sessionManager.Token(r.Context())
+	data := make([]sessionData, len(sessions))
+	for i, s := range sessions {
+		data[i] = sessionData{s, s.Token == token}
+	}
+
+	return app.render(w, r, http.StatusOK, "account-sessions.tmpl", data)
+}
+
+func (app *application) handleAccountSessionRevokePost(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Session.Delete(r.Context(), suid, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+
+		return err
+	}
+
+	err = app.audit(r, models.AuditSessionRevoked, models.AuditSuccess, suid, "")
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Signed out device.",
+	})
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) handleAccountSessionsRevokeOthersPost(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Session.DeleteAllForUser(r.Context(), suid, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return err
+	}
+
+	err = app.audit(r, models.AuditSessionRevoked, models.AuditSuccess, suid, "")
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Signed out all other devices.",
+	})
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+
+	return nil
+}
+
+// Periodically remove expired sessions from the index. The session store
+// deletes expired sessions itself, but doesn't know about the index.
+func (app *application) purgeExpiredSessions(interval time.Duration) {
+	ctx, cancel := app.shutdownContext()
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.models.Session.DeleteExpired(ctx)
+		if err != nil {
+			app.logger.Error("purge expired sessions", slog.Any("err", err))
+		}
+		if n > 0 {
+			app.logger.Debug("purged expired sessions", slog.Int("count", n))
+		}
+	}
+}
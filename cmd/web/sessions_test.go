@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExpiredSessionsArePurged(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	sessions, err := app.models.Session.GetAllForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions; want 1", len(sessions))
+	}
+
+	// Expired and deleted by the session store
+	err = app.sessionManager.Store.Delete(sessions[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := app.models.Session.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d purged; want 1", n)
+	}
+
+	sessions, err = app.models.Session.GetAllForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("got %d sessions; want none", len(sessions))
+	}
+
+	assertAuthenticated(t, ts, false)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"html/template"
 	"io/fs"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/micahco/web/internal/models"
 	"github.com/micahco/web/ui"
 )
 
@@ -19,6 +21,8 @@ type templateData struct {
 	Flash           *FlashMessage
 	FormErrors      FormErrors
 	IsAuthenticated bool
+	IsAdmin         bool
+	IsDev           bool
 	Data            any
 }
 
@@ -29,6 +33,8 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, statusCod
 		Flash:           app.popFlash(r),
 		FormErrors:      app.popFormErrors(r),
 		IsAuthenticated: app.isAuthenticated(r),
+		IsAdmin:         app.roles(r).Can(models.PermissionViewUsers),
+		IsDev:           app.config.dev,
 		CSRFToken:       nosurf.Token(r),
 		Data:            data,
 	}
@@ -90,7 +96,15 @@ func writeTemplate(t *template.Template, td templateData, w http.ResponseWriter,
 	return nil
 }
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"base64url": base64.RawURLEncoding.EncodeToString,
+	"date":      date,
+	"sub":       func(a, b int) int { return a - b },
+}
+
+func date(t time.Time) string {
+	return t.Format("2006-01-02 15:04")
+}
 
 // Create new template cache with ui.Files embedded file system.
 // Creates a template for each page in the web/pages directory
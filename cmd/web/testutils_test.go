@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/micahco/web/internal/mailer"
+	"github.com/micahco/web/internal/models"
+	"github.com/micahco/web/ui"
+)
+
+const testBaseURL = "https://example.com"
+
+// Create application with in-memory models. Sent mail is captured in the
+// returned mailbox.
+func newTestApplication(t *testing.T) (*application, *mailer.Mailbox) {
+	t.Helper()
+
+	baseURL, err := url.Parse(testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailbox := mailer.NewMailbox(nil, 100)
+	sender := &mail.Address{Name: "Do Not Reply", Address: "noreply@example.com"}
+
+	m, err := mailer.New(mailbox, sender, baseURL, ui.Files, "mail/base.tmpl", "mail/messages/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: baseURL.Hostname(),
+		RPID:          baseURL.Hostname(),
+		RPOrigins:     []string{testBaseURL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := newSessionManager(memstore.NewWithCleanupInterval(0))
+
+	app := &application{
+		baseURL:        baseURL,
+		config:         config{url: testBaseURL},
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mailer:         m,
+		models:         models.NewMemory(sm.Store),
+		sessionManager: sm,
+		templateCache:  tc,
+		formDecoder:    form.NewDecoder(),
+		validate:       validator.New(),
+		webauthn:       wa,
+		shutdown:       make(chan struct{}),
+	}
+
+	return app, mailbox
+}
+
+// Test server with a client that keeps cookies and doesn't follow
+// redirects.
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	// TLS, since the CSRF cookie is secure
+	ts := httptest.NewTLSServer(h)
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.Client().Jar = jar
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &testServer{ts}
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (ts *testServer) do(t *testing.T, req *http.Request) testResponse {
+	t.Helper()
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testResponse{res.StatusCode, res.Header, string(body)}
+}
+
+func (ts *testServer) get(t *testing.T, path string) testResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ts.do(t, req)
+}
+
+// Post form with a CSRF token, as if submitted from the index page.
+func (ts *testServer) postForm(t *testing.T, path string, form url.Values) testResponse {
+	t.Helper()
+
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("csrf_token", ts.csrfToken(t))
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", ts.URL+"/")
+
+	return ts.do(t, req)
+}
+
+// Post JSON with a CSRF token header, like the passkey scripts do.
+func (ts *testServer) postJSON(t *testing.T, path string, body []byte) testResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", ts.csrfToken(t))
+	req.Header.Set("Referer", ts.URL+"/")
+
+	return ts.do(t, req)
+}
+
+var csrfTokenRX = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// Get a CSRF token from a page that renders for any client.
+func (ts *testServer) csrfToken(t *testing.T) string {
+	t.Helper()
+
+	res := ts.get(t, "/auth/reset")
+
+	m := csrfTokenRX.FindStringSubmatch(res.body)
+	if m == nil {
+		t.Fatalf("no csrf token in %s", res.body)
+	}
+
+	return html.UnescapeString(m[1])
+}
+
+var mailLinkRX = regexp.MustCompile(regexp.QuoteMeta(testBaseURL) + `(/\S+)`)
+
+// Get the path and query of the link in the most recent mail to recipient.
+func mailLink(t *testing.T, mailbox *mailer.Mailbox, recipient string) string {
+	t.Helper()
+
+	for _, msg := range mailbox.Messages() {
+		if len(msg.To) != 1 || msg.To[0] != recipient {
+			continue
+		}
+
+		m := mailLinkRX.FindStringSubmatch(msg.Text)
+		if m == nil {
+			t.Fatalf("no link in mail %q", msg.Text)
+		}
+
+		return m[1]
+	}
+
+	t.Fatalf("no mail to %s", recipient)
+
+	return ""
+}
+
+func assertStatus(t *testing.T, res testResponse, want int) {
+	t.Helper()
+
+	if res.status != want {
+		t.Fatalf("got status %d; want %d: %s", res.status, want, res.body)
+	}
+}
+
+func assertRedirect(t *testing.T, res testResponse, location string) {
+	t.Helper()
+
+	assertStatus(t, res, http.StatusSeeOther)
+
+	if got := res.header.Get("Location"); got != location {
+		t.Fatalf("got redirect to %q; want %q", got, location)
+	}
+}
+
+// Assert that the most recent audit event has type and outcome.
+func assertAuditEvent(t *testing.T, app *application, typ models.AuditEventType, outcome models.AuditOutcome) {
+	t.Helper()
+
+	events, err := app.models.AuditEvent.GetAll(context.Background(), models.AuditFilter{}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) == 0 || events[0].Type != typ || events[0].Outcome != outcome {
+		t.Fatalf("got audit events %v; want %s %s", events, typ, outcome)
+	}
+}
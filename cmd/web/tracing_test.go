@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Install a tracer provider that records ended spans in memory.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+	})
+
+	return sr
+}
+
+func TestTrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		route  string
+		status int
+	}{
+		{"static route", http.MethodGet, "/healthz", "/healthz", http.StatusOK},
+		{"route pattern", http.MethodPost, "/account/sessions/" + uuid.Must(uuid.NewV4()).String() + "/revoke", "/account/sessions/{id}/revoke", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := recordSpans(t)
+
+			var logs bytes.Buffer
+			app, _ := newTestApplication(t)
+			app.logger = slog.New(traceHandler{slog.NewJSONHandler(&logs, nil)})
+
+			ts := newTestServer(t, app.routes())
+
+			var res testResponse
+			if tt.method == http.MethodPost {
+				res = ts.postForm(t, tt.path, nil)
+			} else {
+				res = ts.get(t, tt.path)
+			}
+			assertStatus(t, res, tt.status)
+
+			// Posting forms first gets a CSRF token, so use the last span
+			spans := sr.Ended()
+			if len(spans) == 0 {
+				t.Fatal("got no spans")
+			}
+			span := spans[len(spans)-1]
+
+			if want := tt.method + " " + tt.route; span.Name() != want {
+				t.Errorf("got span name %q; want %q", span.Name(), want)
+			}
+
+			attrs := attribute.NewSet(span.Attributes()...)
+			if v, ok := attrs.Value(semconv.HTTPResponseStatusCodeKey); !ok || v.AsInt64() != int64(tt.status) {
+				t.Errorf("got status attribute %v; want %d", v.Emit(), tt.status)
+			}
+			if v, ok := attrs.Value(semconv.HTTPRouteKey); !ok || v.AsString() != tt.route {
+				t.Errorf("got route attribute %q; want %q", v.Emit(), tt.route)
+			}
+
+			// The last access log record is correlated with the span
+			lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+			line := lines[len(lines)-1]
+
+			var record struct {
+				Msg     string `json:"msg"`
+				TraceID string `json:"trace_id"`
+				SpanID  string `json:"span_id"`
+			}
+
+			err := json.Unmarshal(line, &record)
+			if err != nil {
+				t.Fatalf("decode log %q: %v", line, err)
+			}
+
+			sc := span.SpanContext()
+			if record.Msg != "request" || record.TraceID != sc.TraceID().String() || record.SpanID != sc.SpanID().String() {
+				t.Errorf("got log %s; want request record with trace_id %s and span_id %s", line, sc.TraceID(), sc.SpanID())
+			}
+		})
+	}
+}
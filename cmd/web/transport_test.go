@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewMailTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		transport string
+		want      string
+	}{
+		{"smtp", "*mailer.SMTPTransport"},
+		{"file", "*mailer.FileTransport"},
+		{"log", "*mailer.LogTransport"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			var cfg config
+			cfg.mail.transport = tt.transport
+			cfg.mail.dir = dir
+			cfg.smtp.host = "localhost"
+			cfg.smtp.port = 2525
+
+			transport, err := newMailTransport(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fmt.Sprintf("%T", transport); got != tt.want {
+				t.Fatalf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The file transport creates its directory
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("got %v; want mail directory", err)
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		var cfg config
+		cfg.mail.transport = "carrier-pigeon"
+
+		transport, err := newMailTransport(cfg, logger)
+		if err == nil || !strings.Contains(err.Error(), `unknown mail transport "carrier-pigeon"`) {
+			t.Fatalf("got %v, %v; want unknown transport error", transport, err)
+		}
+	})
+}
+
+func TestConfigMailTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"smtp", map[string]string{"WEB_MAIL_TRANSPORT": "smtp", "WEB_SMTP_HOST": "localhost"}, ""},
+		{"smtp without host", map[string]string{"WEB_MAIL_TRANSPORT": "smtp"}, "smtp-host is required"},
+		{"file", map[string]string{"WEB_MAIL_TRANSPORT": "file"}, ""},
+		{"unknown", map[string]string{"WEB_MAIL_TRANSPORT": "carrier-pigeon"}, `unknown mail-transport "carrier-pigeon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{
+				"WEB_URL":       "https://example.com",
+				"WEB_SMTP_ADDR": "web@example.com",
+				"WEB_DB_DSN":    "postgres://env",
+			}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+
+			_, err := loadConfig(nil, func(key string) string {
+				return env[key]
+			})
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
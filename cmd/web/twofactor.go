@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"image/png"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+var totpCodeRX = regexp.MustCompile(`^\d{6}$`)
+
+// Period of TOTP codes in seconds, the default of authenticator apps
+const totpPeriod = 30
+
+// Get the time step that TOTP code is valid for, allowing one step of
+// clock skew either way like totp.Validate.
+func totpStep(code, secret string, t time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	step := t.Unix() / totpPeriod
+	for _, s := range []int64{step - 1, step, step + 1} {
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(s*totpPeriod, 0), opts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// Check TOTP code of user. Each code is only accepted once, so a code
+// that has been seen can't be replayed while it's still valid.
+func (app *application) useTOTP(r *http.Request, user *models.User, code string) (bool, error) {
+	if !user.HasTOTP() {
+		return false, nil
+	}
+
+	step, ok := totpStep(code, user.TOTPSecret, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err := app.models.User.UseTOTPStep(r.Context(), user.ID, step)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Login user, or hold them in a pending state until they provide a
+// second factor if they have enabled two-factor authentication.
+func (app *application) loginWithSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	if user.IsDisabled() {
+		return app.renderError(w, r, http.StatusForbidden, accountDisabledMessage)
+	}
+
+	if !user.HasTOTP() {
+		err := app.login(r, user.ID)
+		if err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+		return nil
+	}
+
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), twoFactorUserIDSessionKey, user.ID)
+	http.Redirect(w, r, "/auth/2fa/verify", http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) totpIssuer() string {
+	if h := app.baseURL.Hostname(); h != "" {
+		return h
+	}
+
+	return "web"
+}
+
+// Get the pending TOTP key from the session, or generate a new one.
+func (app *application) pendingTOTPKey(r *http.Request, email string) (*otp.Key, error) {
+	s := app.sessionManager.GetString(r.Context(), twoFactorSecretSessionKey)
+	if s != "" {
+		return otp.NewKeyFromURL(s)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      app.totpIssuer(),
+		AccountName: email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.sessionManager.Put(r.Context(), twoFactorSecretSessionKey, key.String())
+
+	return key, nil
+}
+
+func (app *application) handleAuthTwoFactorGet(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Enabled bool
+		URI     string
+		Secret  string
+	}
+
+	data.Enabled = user.HasTOTP()
+	if !data.Enabled {
+		key, err := app.pendingTOTPKey(r, user.Email)
+		if err != nil {
+			return err
+		}
+
+		data.URI = key.URL()
+		data.Secret = key.Secret()
+	}
+
+	return app.render(w, r, http.StatusOK, "auth-2fa.tmpl", data)
+}
+
+func (app *application) handleAuthTwoFactorQRGet(w http.ResponseWriter, r *http.Request) error {
+	s := app.sessionManager.GetString(r.Context(), twoFactorSecretSessionKey)
+	if s == "" {
+		return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	key, err := otp.NewKeyFromURL(s)
+	if err != nil {
+		return err
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+
+	return png.Encode(w, img)
+}
+
+func (app *application) handleAuthTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) error {
+	var form struct {
+		Code string `form:"code" validate:"required,len=6,numeric"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	s := app.sessionManager.GetString(r.Context(), twoFactorSecretSessionKey)
+	if s == "" {
+		return app.renderError(w, r, http.StatusBadRequest, "missing pending secret")
+	}
+
+	key, err := otp.NewKeyFromURL(s)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totpStep(form.Code, key.Secret(), time.Now())
+	if !ok {
+		return FormErrors{"Code": "invalid code"}
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.User.EnableTOTP(r.Context(), suid, key.Secret())
+	if err != nil {
+		return err
+	}
+
+	// The confirmation code can't be used again to login
+	err = app.models.User.UseTOTPStep(r.Context(), suid, step)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), twoFactorSecretSessionKey)
+
+	codes, err := app.models.User.NewRecoveryCodes(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Two-factor authentication enabled.",
+	})
+
+	return app.render(w, r, http.StatusOK, "auth-2fa-recovery.tmpl", codes)
+}
+
+// Check TOTP code of user that already has two-factor authentication enabled.
+func (app *application) validateTOTP(r *http.Request) (*models.User, error) {
+	var form struct {
+		Code string `form:"code" validate:"required,len=6,numeric"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return nil, err
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), suid)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := app.useTOTP(r, user, form.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		return nil, FormErrors{"Code": "invalid code"}
+	}
+
+	return user, nil
+}
+
+func (app *application) handleAuthTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.validateTOTP(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.User.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Two-factor authentication disabled.",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+func (app *application) handleAuthTwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) error {
+	user, err := app.validateTOTP(r)
+	if err != nil {
+		return err
+	}
+
+	codes, err := app.models.User.NewRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.render(w, r, http.StatusOK, "auth-2fa-recovery.tmpl", codes)
+}
+
+func (app *application) handleAuthTwoFactorVerifyGet(w http.ResponseWriter, r *http.Request) error {
+	if !app.sessionManager.Exists(r.Context(), twoFactorUserIDSessionKey) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+		return nil
+	}
+
+	return app.render(w, r, http.StatusOK, "auth-2fa-verify.tmpl", nil)
+}
+
+func (app *application) handleAuthTwoFactorVerifyPost(w http.ResponseWriter, r *http.Request) error {
+	id, ok := app.sessionManager.Get(r.Context(), twoFactorUserIDSessionKey).(uuid.UUID)
+	if !ok {
+		return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	}
+
+	var form struct {
+		Code string `form:"code" validate:"required,max=32"`
+	}
+
+	err := app.parseForm(r, &form)
+	if err != nil {
+		return err
+	}
+
+	// Give up on the pending login after too many invalid codes
+	key := models.LoginAttemptTwoFactorKey(id)
+	err = app.models.LoginAttempt.Check(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLockedOut):
+			return app.abortTwoFactor(w, r)
+		default:
+			return err
+		}
+	}
+
+	user, err := app.models.User.GetWithID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	// Six digit codes are TOTP codes, anything else is a recovery code
+	valid := true
+	if totpCodeRX.MatchString(form.Code) {
+		valid, err = app.useTOTP(r, user, form.Code)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = app.models.User.UseRecoveryCode(r.Context(), user.ID, form.Code)
+		if err != nil {
+			if !errors.Is(err, models.ErrInvalidCredentials) {
+				return err
+			}
+
+			valid = false
+		}
+	}
+
+	if !valid {
+		err = app.audit(r, models.AuditLogin, models.AuditFailure, user.ID, user.Email)
+		if err != nil {
+			return err
+		}
+
+		locked, err := app.models.LoginAttempt.Fail(r.Context(), key, twoFactorLockoutThreshold)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			return app.abortTwoFactor(w, r)
+		}
+
+		return FormErrors{"Code": "invalid code"}
+	}
+
+	err = app.models.LoginAttempt.Reset(r.Context(), key)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), twoFactorUserIDSessionKey)
+
+	err = app.login(r, user.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+// Drop the pending login, so the user has to provide their password again.
+func (app *application) abortTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	app.sessionManager.Remove(r.Context(), twoFactorUserIDSessionKey)
+
+	return app.renderError(w, r, http.StatusTooManyRequests, "Too many invalid codes. Please try again later.")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// Create user with TOTP enabled and return its secret.
+func newTwoFactorUser(t *testing.T, app *application) string {
+	t.Helper()
+
+	user, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: testEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.User.EnableTOTP(context.Background(), user.ID, key.Secret())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.Secret()
+}
+
+func verifyCode(t *testing.T, ts *testServer, code string) testResponse {
+	t.Helper()
+
+	return ts.postForm(t, "/auth/2fa/verify", url.Values{"code": {code}})
+}
+
+func TestTwoFactorReplay(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	secret := newTwoFactorUser(t, app)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/auth/2fa/verify")
+
+	res = verifyCode(t, ts, code)
+	assertRedirect(t, res, "/")
+	assertAuthenticated(t, ts, true)
+
+	res = ts.postForm(t, "/auth/logout", nil)
+	assertRedirect(t, res, "/")
+
+	// Same code again, while it's still valid
+	res = login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/auth/2fa/verify")
+
+	res = verifyCode(t, ts, code)
+	assertRedirect(t, res, ts.URL+"/")
+	assertAuthenticated(t, ts, false)
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	secret := newTwoFactorUser(t, app)
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/auth/2fa/verify")
+
+	for range twoFactorLockoutThreshold - 1 {
+		res = verifyCode(t, ts, "not-a-recovery-code")
+		assertRedirect(t, res, ts.URL+"/")
+	}
+
+	res = verifyCode(t, ts, "not-a-recovery-code")
+	assertStatus(t, res, http.StatusTooManyRequests)
+
+	// The pending login was dropped, so even a valid code is rejected
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = verifyCode(t, ts, code)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	// And starting over is locked out for now
+	res = login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/auth/2fa/verify")
+
+	res = verifyCode(t, ts, code)
+	assertStatus(t, res, http.StatusTooManyRequests)
+	assertAuthenticated(t, ts, false)
+}
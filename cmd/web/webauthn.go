@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/internal/models"
+)
+
+// Wraps user and their credentials to satisfy webauthn.User
+type passkeyUser struct {
+	*models.User
+	credentials []*models.Credential
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return u.ID.Bytes()
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.Email
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		creds[i] = c.Data
+	}
+
+	return creds
+}
+
+func (app *application) getPasskeyUser(ctx context.Context, id uuid.UUID) (*passkeyUser, error) {
+	user, err := app.models.User.GetWithID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := app.models.Credential.GetAllForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{user, creds}, nil
+}
+
+// Store ceremony session data as JSON, since it doesn't gob encode.
+func (app *application) putWebAuthnSession(r *http.Request, key string, sd *webauthn.SessionData) error {
+	b, err := json.Marshal(sd)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), key, b)
+
+	return nil
+}
+
+func (app *application) popWebAuthnSession(r *http.Request, key string) (*webauthn.SessionData, error) {
+	b, ok := app.sessionManager.Pop(r.Context(), key).([]byte)
+	if !ok {
+		return nil, errors.New("missing webauthn session data")
+	}
+
+	var sd webauthn.SessionData
+	err := json.Unmarshal(b, &sd)
+
+	return &sd, err
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(b)
+
+	return err
+}
+
+func (app *application) handleWebAuthnRegisterBeginPost(w http.ResponseWriter, r *http.Request) error {
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getPasskeyUser(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		exclusions[i] = c.Data.Descriptor()
+	}
+
+	creation, sd, err := app.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = app.putWebAuthnSession(r, webauthnRegistrationSessionKey, sd)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, creation)
+}
+
+func (app *application) handleWebAuthnRegisterFinishPost(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("name")
+	if name == "" || len(name) > 64 {
+		return app.renderError(w, r, http.StatusBadRequest, "invalid passkey name")
+	}
+
+	sd, err := app.popWebAuthnSession(r, webauthnRegistrationSessionKey)
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, err.Error())
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getPasskeyUser(r.Context(), suid)
+	if err != nil {
+		return err
+	}
+
+	cred, err := app.webauthn.FinishRegistration(user, *sd, r)
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, "passkey registration failed")
+	}
+
+	c := &models.Credential{
+		UserID: user.ID,
+		Name:   name,
+		Data:   *cred,
+	}
+
+	err = app.models.Credential.Insert(r.Context(), c)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, map[string]string{"redirect": "/"})
+}
+
+func (app *application) handleWebAuthnLoginBeginPost(w http.ResponseWriter, r *http.Request) error {
+	if app.isAuthenticated(r) {
+		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
+	}
+
+	assertion, sd, err := app.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return err
+	}
+
+	err = app.putWebAuthnSession(r, webauthnLoginSessionKey, sd)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, assertion)
+}
+
+func (app *application) handleWebAuthnLoginFinishPost(w http.ResponseWriter, r *http.Request) error {
+	if app.isAuthenticated(r) {
+		return app.renderError(w, r, http.StatusBadRequest, "already authenticated")
+	}
+
+	sd, err := app.popWebAuthnSession(r, webauthnLoginSessionKey)
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, err.Error())
+	}
+
+	// Find the user from the user handle stored on the authenticator.
+	var user *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err = app.getPasskeyUser(r.Context(), id)
+
+		return user, err
+	}
+
+	cred, err := app.webauthn.FinishDiscoverableLogin(handler, *sd, r)
+	if err != nil || cred.Authenticator.CloneWarning {
+		// User is only known if the user handle matched
+		var userID uuid.UUID
+		var email string
+		if user != nil {
+			userID, email = user.ID, user.Email
+		}
+
+		err = app.audit(r, models.AuditLogin, models.AuditFailure, userID, email)
+		if err != nil {
+			return err
+		}
+
+		return app.renderError(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	}
+
+	if user.IsDisabled() {
+		err = app.audit(r, models.AuditLogin, models.AuditDisabled, user.ID, user.Email)
+		if err != nil {
+			return err
+		}
+
+		return app.renderError(w, r, http.StatusForbidden, accountDisabledMessage)
+	}
+
+	err = app.models.Credential.UpdateAfterLogin(r.Context(), *cred)
+	if err != nil {
+		return err
+	}
+
+	err = app.login(r, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+}
+
+func (app *application) handleWebAuthnCredentialDeletePost(w http.ResponseWriter, r *http.Request) error {
+	id, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.renderError(w, r, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	}
+
+	suid, err := app.getSessionUserID(r)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Credential.Delete(r.Context(), suid, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return app.renderError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+
+		return err
+	}
+
+	app.putFlash(r, FlashMessage{
+		Type:    FlashSuccess,
+		Message: "Passkey removed.",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/micahco/web/internal/models"
+)
+
+var b64 = base64.RawURLEncoding
+
+// Software authenticator with a single P-256 passkey, which signs like a
+// hardware key but lets tests choose the challenge and sign count.
+type testAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credID := make([]byte, 16)
+	_, err = rand.Read(credID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testAuthenticator{key: key, credID: credID}
+}
+
+func (a *testAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testBaseURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func (a *testAuthenticator) authData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attested...)
+}
+
+// Create the credential for the challenge of a registration ceremony.
+func (a *testAuthenticator) create(t *testing.T, challenge string) []byte {
+	t.Helper()
+
+	pub, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zero AAGUID, credential ID and public key
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, pub...)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flags, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64.EncodeToString(a.clientData(t, "webauthn.create", challenge)),
+		"attestationObject": b64.EncodeToString(attestation),
+	})
+}
+
+// Sign the challenge of a login ceremony with the current sign count.
+func (a *testAuthenticator) get(t *testing.T, challenge string) []byte {
+	t.Helper()
+
+	authData := a.authData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64.EncodeToString(clientData),
+		"authenticatorData": b64.EncodeToString(authData),
+		"signature":         b64.EncodeToString(sig),
+		"userHandle":        b64.EncodeToString(a.userHandle),
+	})
+}
+
+func (a *testAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]any{
+		"id":       b64.EncodeToString(a.credID),
+		"rawId":    b64.EncodeToString(a.credID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// Begin ceremony and return its challenge and the user handle, if any.
+func beginCeremony(t *testing.T, ts *testServer, path string) (string, []byte) {
+	t.Helper()
+
+	res := ts.postJSON(t, path, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+
+	err := json.Unmarshal([]byte(res.body), &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle, err := b64.DecodeString(options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return options.PublicKey.Challenge, handle
+}
+
+// Login with password and register a passkey, then logout.
+func registerPasskey(t *testing.T, ts *testServer, a *testAuthenticator) {
+	t.Helper()
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	challenge, handle := beginCeremony(t, ts, "/auth/webauthn/register/begin")
+	a.userHandle = handle
+
+	res = ts.postJSON(t, "/auth/webauthn/register/finish?name=Laptop", a.create(t, challenge))
+	assertStatus(t, res, http.StatusCreated)
+
+	res = ts.postForm(t, "/auth/logout", nil)
+	assertRedirect(t, res, "/")
+}
+
+func TestWebAuthn(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestAuthenticator(t)
+	registerPasskey(t, ts, a)
+
+	a.signCount = 1
+	challenge, _ := beginCeremony(t, ts, "/auth/webauthn/login/begin")
+
+	res := ts.postJSON(t, "/auth/webauthn/login/finish", a.get(t, challenge))
+	assertStatus(t, res, http.StatusOK)
+	assertAuthenticated(t, ts, true)
+}
+
+func TestWebAuthnRegisterBadChallenge(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := login(t, ts, testEmail, testPassword)
+	assertRedirect(t, res, "/")
+
+	_, handle := beginCeremony(t, ts, "/auth/webauthn/register/begin")
+
+	a := newTestAuthenticator(t)
+	a.userHandle = handle
+
+	res = ts.postJSON(t, "/auth/webauthn/register/finish?name=Laptop", a.create(t, b64.EncodeToString([]byte("wrong challenge"))))
+	assertStatus(t, res, http.StatusBadRequest)
+}
+
+func TestWebAuthnLoginRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		signCount uint32
+		challenge func(challenge string) string
+	}{
+		{"bad challenge", 6, func(string) string { return b64.EncodeToString([]byte("wrong challenge")) }},
+		{"sign count regression", 0, func(challenge string) string { return challenge }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+
+			_, err := app.models.User.New(context.Background(), testEmail, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := newTestAuthenticator(t)
+			a.signCount = 5
+			registerPasskey(t, ts, a)
+
+			a.signCount = tt.signCount
+			challenge, _ := beginCeremony(t, ts, "/auth/webauthn/login/begin")
+
+			res := ts.postJSON(t, "/auth/webauthn/login/finish", a.get(t, tt.challenge(challenge)))
+			assertStatus(t, res, http.StatusUnauthorized)
+			assertAuditEvent(t, app, models.AuditLogin, models.AuditFailure)
+			assertAuthenticated(t, ts, false)
+		})
+	}
+}
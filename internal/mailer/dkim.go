@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Headers covered by the signature, as recommended by RFC 6376
+var dkimHeaderKeys = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Mime-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// Transport that signs messages with DKIM before passing them to the
+// next transport.
+type DKIMTransport struct {
+	next    Transport
+	options *dkim.SignOptions
+}
+
+// Create transport that signs for domain with the key published under
+// selector. The key must be an RSA or Ed25519 private key.
+func NewDKIMTransport(next Transport, domain, selector string, key crypto.Signer) (*DKIMTransport, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("mailer: unsupported dkim key type %T", key)
+	}
+
+	if domain == "" || selector == "" {
+		return nil, errors.New("mailer: dkim domain and selector are required")
+	}
+
+	return &DKIMTransport{
+		next: next,
+		options: &dkim.SignOptions{
+			Domain:                 domain,
+			Selector:               selector,
+			Signer:                 key,
+			HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+			BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+			HeaderKeys:             dkimHeaderKeys,
+		},
+	}, nil
+}
+
+func (t *DKIMTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	signed := new(bytes.Buffer)
+	err := dkim.Sign(signed, bytes.NewReader(msg), t.options)
+	if err != nil {
+		return err
+	}
+
+	return t.next.Send(ctx, from, to, signed.Bytes())
+}
+
+// Read PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8) private key.
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("mailer: no PEM data in dkim key file")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("mailer: unsupported dkim key type %T", key)
+		}
+
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("mailer: unsupported PEM block %q in dkim key file", block.Type)
+	}
+}
@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const testMessage = "From: sender@example.com\r\n" +
+	"To: recipient@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"Mime-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello, world!\r\n"
+
+func TestDKIMTransport(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPub, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		record string
+	}{
+		{
+			name:   "rsa",
+			key:    rsaKey,
+			record: "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		},
+		{
+			name:   "ed25519",
+			key:    edKey,
+			record: "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := loadTestKey(t, tt.key)
+
+			mem := NewMemoryTransport()
+			tr, err := NewDKIMTransport(mem, "example.com", "mail", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tr.Send(context.Background(), "sender@example.com", []string{"recipient@example.com"}, []byte(testMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := mem.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("got %d messages, want 1", len(msgs))
+			}
+
+			lookup := func(domain string) ([]string, error) {
+				if domain != "mail._domainkey.example.com" {
+					t.Errorf("lookup of unexpected domain %q", domain)
+				}
+
+				return []string{tt.record}, nil
+			}
+
+			verify := func(msg []byte) error {
+				verifications, err := dkim.VerifyWithOptions(bytes.NewReader(msg), &dkim.VerifyOptions{LookupTXT: lookup})
+				if err != nil {
+					return err
+				}
+
+				if len(verifications) != 1 {
+					t.Fatalf("got %d signatures, want 1", len(verifications))
+				}
+
+				return verifications[0].Err
+			}
+
+			err = verify(msgs[0].Data)
+			if err != nil {
+				t.Errorf("verify signed message: %v", err)
+			}
+
+			tampered := bytes.Replace(msgs[0].Data, []byte("Hello, world!"), []byte("Hello, spam!"), 1)
+			if verify(tampered) == nil {
+				t.Error("verify tampered message: got valid signature")
+			}
+		})
+	}
+}
+
+// Write key as a PEM file and load it again
+func loadTestKey(t *testing.T, key crypto.Signer) crypto.Signer {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	err = os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadDKIMKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
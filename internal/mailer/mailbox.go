@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message captured by a mailbox, decoded for display
+type MailboxMessage struct {
+	ID      int
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Date    time.Time
+}
+
+// Transport that keeps the most recent messages in memory before
+// passing them on to the next transport, for viewing during development.
+type Mailbox struct {
+	next     Transport
+	capacity int
+
+	mu       sync.Mutex
+	lastID   int
+	messages []*MailboxMessage
+}
+
+// Create mailbox that keeps up to capacity messages. Next may be nil to
+// only capture messages.
+func NewMailbox(next Transport, capacity int) *Mailbox {
+	return &Mailbox{next: next, capacity: capacity}
+}
+
+func (mb *Mailbox) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	m, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	m.From = from
+	m.To = to
+
+	mb.mu.Lock()
+	mb.lastID++
+	m.ID = mb.lastID
+	mb.messages = append(mb.messages, m)
+	if len(mb.messages) > mb.capacity {
+		mb.messages = mb.messages[1:]
+	}
+	mb.mu.Unlock()
+
+	if mb.next == nil {
+		return nil
+	}
+
+	return mb.next.Send(ctx, from, to, msg)
+}
+
+// Get captured messages, most recent first.
+func (mb *Mailbox) Messages() []*MailboxMessage {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	msgs := make([]*MailboxMessage, len(mb.messages))
+	for i, m := range mb.messages {
+		msgs[len(msgs)-1-i] = m
+	}
+
+	return msgs
+}
+
+// Get captured message with id, or nil if there is none.
+func (mb *Mailbox) Get(id int) *MailboxMessage {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for _, m := range mb.messages {
+		if m.ID == id {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func parseMessage(msg []byte) (*MailboxMessage, error) {
+	pm, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MailboxMessage{Date: time.Now()}
+
+	dec := new(mime.WordDecoder)
+	m.Subject, err = dec.DecodeHeader(pm.Header.Get("Subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	if d, err := pm.Header.Date(); err == nil {
+		m.Date = d
+	}
+
+	mediaType, params, err := mime.ParseMediaType(pm.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := readBody(pm.Body, pm.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, err
+		}
+
+		m.setBody(mediaType, body)
+
+		return m, nil
+	}
+
+	// Parts are decoded from quoted-printable by the multipart reader
+	mr := multipart.NewReader(pm.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := readBody(p, p.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		m.setBody(partType, body)
+	}
+
+	return m, nil
+}
+
+func readBody(r io.Reader, encoding string) (string, error) {
+	if strings.EqualFold(encoding, "quoted-printable") {
+		r = quotedprintable.NewReader(r)
+	}
+
+	b, err := io.ReadAll(r)
+
+	return string(b), err
+}
+
+func (m *MailboxMessage) setBody(mediaType, body string) {
+	switch mediaType {
+	case "text/plain":
+		m.Text = body
+	case "text/html":
+		m.HTML = body
+	}
+}
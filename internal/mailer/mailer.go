@@ -2,25 +2,54 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"fmt"
+	htmltemplate "html/template"
 	"io/fs"
 	"net/mail"
+	"net/url"
 	"path/filepath"
 	"text/template"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gomail.v2"
 )
 
+var tracer = otel.Tracer("github.com/micahco/web/internal/mailer")
+
 type Mailer struct {
-	dialer        *gomail.Dialer
+	transport     Transport
 	sender        *mail.Address
-	templateCache map[string]*template.Template
+	site          *url.URL
+	templateCache map[string]*messageTemplate
+}
+
+// Text and HTML templates parsed from the same files. The HTML template
+// is nil if the message does not define an html block.
+type messageTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
 }
 
-// Create new mailer with SMTP credentials and embedded fs using glob pattern
-func New(host string, port int, username string, password string, sender *mail.Address, fsys embed.FS, globPattern string) (*Mailer, error) {
-	cache := map[string]*template.Template{}
+// Data passed to the layout, with message specific data in Data
+type templateData struct {
+	Site      *url.URL
+	Recipient string
+	Data      any
+}
+
+// Create new mailer that sends with transport. Creates a template for each
+// file of embedded fs matching glob pattern, nested with the layout file.
+//
+// Message templates define a subject and a plain text body block, and
+// optionally an html block. The layout wraps them with the text-layout and
+// html-layout blocks.
+func New(transport Transport, sender *mail.Address, site *url.URL, fsys embed.FS, layout, globPattern string) (*Mailer, error) {
+	cache := map[string]*messageTemplate{}
 
 	// Get list of filenames in embed using pattern
 	filenames, err := fs.Glob(fsys, globPattern)
@@ -32,44 +61,69 @@ func New(host string, port int, username string, password string, sender *mail.A
 	for _, fname := range filenames {
 		name := filepath.Base(fname)
 
-		t, err := template.New(name).ParseFS(fsys, fname)
+		t, err := template.New(name).ParseFS(fsys, layout, fname)
 		if err != nil {
 			return nil, err
 		}
 
-		cache[name] = t
+		mt := &messageTemplate{text: t}
+
+		if t.Lookup("html") != nil {
+			mt.html, err = htmltemplate.New(name).ParseFS(fsys, layout, fname)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cache[name] = mt
 	}
 
 	m := &Mailer{
-		dialer:        gomail.NewDialer(host, port, username, password),
+		transport:     transport,
 		sender:        sender,
+		site:          site,
 		templateCache: cache,
 	}
 
-	// Ping the SMTP server to verify authentication
-	s, err := m.dialer.Dial()
+	return m, nil
+}
+
+// Render tmpl and hand it to the transport, within a span of ctx.
+func (m *Mailer) Send(ctx context.Context, recepient, tmpl string, data any) error {
+	ctx, span := tracer.Start(ctx, "mail.send", trace.WithAttributes(
+		attribute.String("mail.template", tmpl),
+	))
+	defer span.End()
+
+	err := m.send(ctx, recepient, tmpl, data)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
-	defer s.Close()
 
-	return m, nil
+	return err
 }
 
-func (m *Mailer) Send(recepient, tmpl string, data interface{}) error {
+func (m *Mailer) send(ctx context.Context, recepient, tmpl string, data any) error {
 	t, ok := m.templateCache[tmpl]
 	if !ok {
 		return fmt.Errorf("template %s does not exist", tmpl)
 	}
 
+	td := templateData{
+		Site:      m.site,
+		Recipient: recepient,
+		Data:      data,
+	}
+
 	subject := new(bytes.Buffer)
-	err := t.ExecuteTemplate(subject, "subject", data)
+	err := t.text.ExecuteTemplate(subject, "subject", td)
 	if err != nil {
 		return err
 	}
 
 	body := new(bytes.Buffer)
-	err = t.ExecuteTemplate(body, "body", data)
+	err = t.text.ExecuteTemplate(body, "text-layout", td)
 	if err != nil {
 		return err
 	}
@@ -80,5 +134,22 @@ func (m *Mailer) Send(recepient, tmpl string, data interface{}) error {
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", body.String())
 
-	return m.dialer.DialAndSend(msg)
+	// Plain text and HTML alternatives make a multipart/alternative message
+	if t.html != nil {
+		html := new(bytes.Buffer)
+		err = t.html.ExecuteTemplate(html, "html-layout", td)
+		if err != nil {
+			return err
+		}
+
+		msg.AddAlternative("text/html", html.String())
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = msg.WriteTo(buf)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(ctx, m.sender.Address, []string{recepient}, buf.Bytes())
 }
@@ -0,0 +1,181 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Delivers a rendered message to recipients. Transports stop when ctx
+// is done, as far as the underlying protocol allows.
+type Transport interface {
+	Send(ctx context.Context, from string, to []string, msg []byte) error
+}
+
+// Implemented by transports that can check if they are able to send.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Sends messages with an SMTP server. A connection is dialed for each
+// message, so the server does not need to be reachable at startup.
+type SMTPTransport struct {
+	dialer *gomail.Dialer
+}
+
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	return &SMTPTransport{gomail.NewDialer(host, port, username, password)}
+}
+
+// The gomail dialer can't be cancelled, so ctx only prevents sending
+// once it is done.
+func (t *SMTPTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	s, err := t.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Send(from, to, bytes.NewBuffer(msg))
+}
+
+// Dial the server and wait for its greeting, bounded by ctx. Doesn't
+// authenticate, so it is cheap enough for readiness probes.
+func (t *SMTPTransport) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.dialer.Host, strconv.Itoa(t.dialer.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.dialer.SSL {
+		conn = tls.Client(conn, &tls.Config{ServerName: t.dialer.Host})
+	}
+
+	c, err := smtp.NewClient(conn, t.dialer.Host)
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Writes each message to an .eml file in a directory.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{dir}, nil
+}
+
+func (t *FileTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000"), hex.EncodeToString(b))
+
+	return os.WriteFile(filepath.Join(t.dir, name), msg, 0o644)
+}
+
+// Check that the directory exists.
+func (t *FileTransport) Ping(ctx context.Context) error {
+	info, err := os.Stat(t.dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("mailer: %s is not a directory", t.dir)
+	}
+
+	return nil
+}
+
+// Writes each message to a logger.
+type LogTransport struct {
+	logger *slog.Logger
+}
+
+func NewLogTransport(logger *slog.Logger) *LogTransport {
+	return &LogTransport{logger}
+}
+
+func (t *LogTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	t.logger.InfoContext(ctx, "mail",
+		slog.String("from", from),
+		slog.String("to", strings.Join(to, ", ")),
+		slog.String("msg", string(msg)),
+	)
+
+	return nil
+}
+
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Keeps sent messages in memory, for tests.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, Message{from, slices.Clone(to), slices.Clone(msg)})
+
+	return nil
+}
+
+// Get all messages sent so far.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.messages)
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	tr, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tr.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		err = tr.Send(context.Background(), "sender@example.com", []string{"recipient@example.com"}, []byte(testMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files; want one per message", len(files))
+	}
+
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != testMessage {
+		t.Fatalf("got %q; want %q", b, testMessage)
+	}
+
+	// Not a directory
+	tr.dir = files[0]
+	if err := tr.Ping(context.Background()); err == nil {
+		t.Fatal("got no error pinging a file")
+	}
+}
+
+func TestLogTransport(t *testing.T) {
+	var logs bytes.Buffer
+	tr := NewLogTransport(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	err := tr.Send(context.Background(), "sender@example.com", []string{"a@example.com", "b@example.com"}, []byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"from=sender@example.com", `to="a@example.com, b@example.com"`, "Hello, world!"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("no %q in log %q", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Prefix of personal access tokens, so they are easy to recognize
+// (e.g. by secret scanners).
+const apiTokenPrefix = "web_"
+
+// What an API token is allowed to do. Write scope includes read.
+type APITokenScope string
+
+const (
+	ScopeRead  = APITokenScope("read")
+	ScopeWrite = APITokenScope("write")
+)
+
+func (s APITokenScope) Valid() bool {
+	return s == ScopeRead || s == ScopeWrite
+}
+
+// Reports whether scope s grants required.
+func (s APITokenScope) Allows(required APITokenScope) bool {
+	return s == required || s == ScopeWrite
+}
+
+type APITokenModel struct {
+	pool *pgxpool.Pool
+}
+
+// Personal access token. Only the hash of the token is stored.
+type APIToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	Scope      APITokenScope
+	Expiry     *time.Time
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (t *APIToken) IsExpired() bool {
+	return t.Expiry != nil && time.Now().After(*t.Expiry)
+}
+
+const apiTokenColumns = "id_, user_id_, name_, scope_, expiry_, created_at_, last_used_at_"
+
+func scanAPIToken(row pgx.CollectableRow) (*APIToken, error) {
+	var t APIToken
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Scope,
+		&t.Expiry,
+		&t.CreatedAt,
+		&t.LastUsedAt)
+
+	return &t, err
+}
+
+// Create new token for user. A nil expiry never expires. Returns the
+// token, which can not be recovered later.
+func (m *APITokenModel) New(ctx context.Context, userID uuid.UUID, name string, scope APITokenScope, expiry *time.Time) (string, *APIToken, error) {
+	token, err := newAPIToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql := `
+		INSERT INTO api_token_ (hash_, user_id_, name_, scope_, expiry_)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING ` + apiTokenColumns + ";"
+
+	args := []any{tokenHash(token), userID, name, scope, expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	t, err := pgx.CollectOneRow(rows, scanAPIToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, t, nil
+}
+
+func newAPIToken() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return apiTokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// Get the unexpired token and mark it as used.
+func (m *APITokenModel) Authenticate(ctx context.Context, token string) (*APIToken, error) {
+	sql := `
+		UPDATE api_token_
+		SET last_used_at_ = NOW()
+		WHERE hash_ = $1 AND (expiry_ IS NULL OR expiry_ > NOW())
+		RETURNING ` + apiTokenColumns + ";"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, tokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := pgx.CollectOneRow(rows, scanAPIToken)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoRecord
+	}
+
+	return t, err
+}
+
+func (m *APITokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*APIToken, error) {
+	sql := `
+		SELECT ` + apiTokenColumns + `
+		FROM api_token_
+		WHERE user_id_ = $1
+		ORDER BY created_at_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanAPIToken)
+}
+
+func (m *APITokenModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	sql := "DELETE FROM api_token_ WHERE user_id_ = $1 AND id_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
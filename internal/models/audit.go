@@ -0,0 +1,125 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuditEventType string
+
+const (
+	AuditLogin           = AuditEventType("login")
+	AuditLogout          = AuditEventType("logout")
+	AuditSignup          = AuditEventType("signup")
+	AuditPasswordReset   = AuditEventType("password_reset")
+	AuditSessionRevoked  = AuditEventType("session_revoked")
+	AuditAccountDisabled = AuditEventType("account_disabled")
+	AuditAccountEnabled  = AuditEventType("account_enabled")
+)
+
+var AuditEventTypes = []AuditEventType{
+	AuditLogin,
+	AuditLogout,
+	AuditSignup,
+	AuditPasswordReset,
+	AuditSessionRevoked,
+	AuditAccountDisabled,
+	AuditAccountEnabled,
+}
+
+type AuditOutcome string
+
+const (
+	AuditSuccess   = AuditOutcome("success")
+	AuditFailure   = AuditOutcome("failure")
+	AuditLockedOut = AuditOutcome("locked_out")
+	AuditDisabled  = AuditOutcome("disabled")
+)
+
+type AuditEventModel struct {
+	pool *pgxpool.Pool
+}
+
+type AuditEvent struct {
+	ID        int64
+	UserID    uuid.NullUUID
+	Email     string
+	Type      AuditEventType
+	Outcome   AuditOutcome
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// Filters events by all non-zero fields.
+type AuditFilter struct {
+	UserID uuid.NullUUID
+	Email  string
+	Type   AuditEventType
+}
+
+func scanAuditEvent(row pgx.CollectableRow) (*AuditEvent, error) {
+	var e AuditEvent
+	err := row.Scan(
+		&e.ID,
+		&e.UserID,
+		&e.Email,
+		&e.Type,
+		&e.Outcome,
+		&e.IP,
+		&e.UserAgent,
+		&e.CreatedAt)
+
+	return &e, err
+}
+
+func (m *AuditEventModel) Insert(ctx context.Context, e *AuditEvent) error {
+	sql := `
+		INSERT INTO audit_event_
+		(user_id_, email_, type_, outcome_, ip_, user_agent_)
+		VALUES($1, $2, $3, $4, $5, $6)
+		RETURNING id_, created_at_;`
+
+	args := []any{e.UserID, e.Email, e.Type, e.Outcome, e.IP, e.UserAgent}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.pool.QueryRow(ctx, sql, args...).Scan(&e.ID, &e.CreatedAt)
+}
+
+// Get events matching filter, most recent first.
+func (m *AuditEventModel) GetAll(ctx context.Context, f AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	sql := `
+		SELECT id_, user_id_, email_, type_, outcome_, ip_, user_agent_, created_at_
+		FROM audit_event_
+		WHERE ($1::uuid IS NULL OR user_id_ = $1)
+		AND ($2 = '' OR email_ = $2)
+		AND ($3 = '' OR type_ = $3)
+		ORDER BY created_at_ DESC, id_ DESC
+		LIMIT $4 OFFSET $5;`
+
+	args := []any{f.UserID, f.Email, f.Type, limit, offset}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanAuditEvent)
+}
+
+func purgeUserAuditEvents(ctx context.Context, tx pgx.Tx, user *User) error {
+	sql := "DELETE FROM audit_event_ WHERE user_id_ = $1 OR email_ = $2;"
+
+	_, err := tx.Exec(ctx, sql, user.ID, user.Email)
+
+	return err
+}
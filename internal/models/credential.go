@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type CredentialModel struct {
+	pool *pgxpool.Pool
+}
+
+// WebAuthn public key credential (passkey) registered by a user.
+type Credential struct {
+	ID         []byte
+	UserID     uuid.UUID
+	Name       string
+	Data       webauthn.Credential
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func scanCredential(row pgx.CollectableRow) (*Credential, error) {
+	var c Credential
+	err := row.Scan(
+		&c.ID,
+		&c.UserID,
+		&c.Name,
+		&c.Data,
+		&c.CreatedAt,
+		&c.LastUsedAt)
+
+	return &c, err
+}
+
+func (m *CredentialModel) Insert(ctx context.Context, c *Credential) error {
+	c.ID = c.Data.ID
+
+	sql := `
+		INSERT INTO credential_ (id_, user_id_, name_, data_)
+		VALUES($1, $2, $3, $4)
+		RETURNING created_at_;`
+
+	args := []any{c.ID, c.UserID, c.Name, c.Data}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.pool.QueryRow(ctx, sql, args...).Scan(&c.CreatedAt)
+}
+
+func (m *CredentialModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Credential, error) {
+	sql := `
+		SELECT id_, user_id_, name_, data_, created_at_, last_used_at_
+		FROM credential_
+		WHERE user_id_ = $1
+		ORDER BY created_at_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanCredential)
+}
+
+// Store updated credential data (e.g. sign count) after a successful login.
+func (m *CredentialModel) UpdateAfterLogin(ctx context.Context, data webauthn.Credential) error {
+	sql := `
+		UPDATE credential_
+		SET data_ = $1, last_used_at_ = NOW()
+		WHERE id_ = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, data, data.ID)
+
+	return err
+}
+
+func (m *CredentialModel) Delete(ctx context.Context, userID uuid.UUID, id []byte) error {
+	sql := "DELETE FROM credential_ WHERE user_id_ = $1 AND id_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Removes rows owned by a user inside the transaction that deletes the
+// user. Data that isn't removed by a foreign key cascade on user_ must
+// have a purger registered in New.
+type userPurger func(ctx context.Context, tx pgx.Tx, user *User) error
+
+// Schedule deletion of user at time.
+func (m *UserModel) ScheduleDeletion(ctx context.Context, id uuid.UUID, at time.Time) error {
+	sql := "UPDATE user_ SET deletion_at_ = $1 WHERE id_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, at, id)
+
+	return err
+}
+
+// Cancel scheduled deletion of user. Reports whether there was one.
+func (m *UserModel) CancelDeletion(ctx context.Context, id uuid.UUID) (bool, error) {
+	sql := `
+		UPDATE user_ SET deletion_at_ = NULL
+		WHERE id_ = $1 AND deletion_at_ IS NOT NULL;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, id)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// Delete all users whose scheduled deletion is due at now, together
+// with all of their data. Returns the number of deleted users.
+func (m *UserModel) PurgeDeleted(ctx context.Context, now time.Time) (int, error) {
+	sql := `
+		SELECT id_, email_
+		FROM user_
+		WHERE deletion_at_ <= $1;`
+
+	// Each deletion gets its own timeout
+	qctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(qctx, sql, now)
+	if err != nil {
+		return 0, err
+	}
+
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*User, error) {
+		var u User
+		err := row.Scan(&u.ID, &u.Email)
+
+		return &u, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, u := range users {
+		err = m.delete(ctx, u)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(users), nil
+}
+
+// Delete user and all of their data in one transaction.
+func (m *UserModel) delete(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		for _, purge := range m.purgers {
+			err := purge(ctx, tx, user)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(ctx, "DELETE FROM user_ WHERE id_ = $1;", user.ID)
+
+		return err
+	})
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdentityModel struct {
+	pool *pgxpool.Pool
+}
+
+// External identity of a user at an OpenID Connect provider.
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    uuid.UUID
+	Email     string
+	CreatedAt time.Time
+}
+
+func (m *IdentityModel) Insert(ctx context.Context, i *Identity) error {
+	sql := `
+		INSERT INTO identity_ (issuer_, subject_, user_id_, email_)
+		VALUES($1, $2, $3, $4)
+		RETURNING created_at_;`
+
+	args := []any{i.Issuer, i.Subject, i.UserID, i.Email}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.pool.QueryRow(ctx, sql, args...).Scan(&i.CreatedAt)
+}
+
+func (m *IdentityModel) Get(ctx context.Context, issuer, subject string) (*Identity, error) {
+	var i Identity
+
+	sql := `
+		SELECT issuer_, subject_, user_id_, email_, created_at_
+		FROM identity_ WHERE issuer_ = $1 AND subject_ = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.pool.QueryRow(ctx, sql, issuer, subject).Scan(
+		&i.Issuer,
+		&i.Subject,
+		&i.UserID,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return &i, nil
+}
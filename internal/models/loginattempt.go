@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Failures older than the window are forgotten
+	loginFailureWindow = 24 * time.Hour
+	// Lockout duration doubles for every failure past the threshold
+	lockoutBase = time.Minute
+	lockoutMax  = 24 * time.Hour
+	// Failures past the threshold after which lockoutBase has doubled
+	// past lockoutMax
+	lockoutSteps = 11
+)
+
+// Tracks failed login attempts by key, i.e. by account and by client IP.
+type LoginAttemptModel struct {
+	pool *pgxpool.Pool
+}
+
+func LoginAttemptEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func LoginAttemptIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Key of second factor attempts of user that has provided their password.
+func LoginAttemptTwoFactorKey(userID uuid.UUID) string {
+	return "2fa:" + userID.String()
+}
+
+// Check if any of keys is locked out. Returns ErrLockedOut if so.
+func (m *LoginAttemptModel) Check(ctx context.Context, keys ...string) error {
+	var locked bool
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM login_attempt_
+			WHERE key_ = ANY($1) AND locked_until_ > NOW()
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.pool.QueryRow(ctx, sql, keys).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		return ErrLockedOut
+	}
+
+	return nil
+}
+
+// Record failed attempt for key and lock it out with exponential
+// backoff once failures reach threshold. Reports whether this failure
+// triggered the first lockout. Counting and locking happen in one
+// statement, so concurrent failures can't slip past the threshold.
+func (m *LoginAttemptModel) Fail(ctx context.Context, key string, threshold int) (bool, error) {
+	var failures int
+
+	// Failures including this one, which start over after the window
+	n := `CASE
+			WHEN a.last_failure_at_ < NOW() - $2::interval THEN 1
+			ELSE a.failures_ + 1
+		END`
+
+	sql := `
+		INSERT INTO login_attempt_ AS a (key_, failures_, locked_until_)
+		VALUES($1, 1, ` + lockedUntil("1", "NULL") + `)
+		ON CONFLICT (key_) DO UPDATE
+		SET failures_ = ` + n + `,
+			locked_until_ = ` + lockedUntil(n, "a.locked_until_") + `,
+			last_failure_at_ = NOW()
+		RETURNING failures_;`
+
+	args := []any{key, loginFailureWindow, threshold, lockoutSeconds()}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.pool.QueryRow(ctx, sql, args...).Scan(&failures)
+	if err != nil {
+		return false, err
+	}
+
+	return failures == threshold, nil
+}
+
+// SQL expression for the end of the lockout after failures, looked up
+// in lockoutSeconds, or current if failures haven't reached the
+// threshold.
+func lockedUntil(failures, current string) string {
+	return `CASE
+			WHEN (` + failures + `) >= $3 THEN NOW() + interval '1 second' *
+				($4::float8[])[LEAST((` + failures + `) - $3, ` + strconv.Itoa(lockoutSteps) + `) + 1]
+			ELSE ` + current + `
+		END`
+}
+
+// Lockout duration after n failures past the threshold.
+func lockoutDuration(n int) time.Duration {
+	if n < lockoutSteps {
+		return min(lockoutBase<<n, lockoutMax)
+	}
+
+	return lockoutMax
+}
+
+// Lockout durations in seconds by failures past the threshold, so the
+// SQL models use the durations of lockoutDuration.
+func lockoutSeconds() []float64 {
+	s := make([]float64, lockoutSteps+1)
+	for n := range s {
+		s[n] = lockoutDuration(n).Seconds()
+	}
+
+	return s
+}
+
+// Clear failed attempts and lockout of key. Returns ErrNoRecord if
+// there was nothing to clear.
+func (m *LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	sql := "DELETE FROM login_attempt_ WHERE key_ = $1;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, key)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func purgeUserLoginAttempts(ctx context.Context, tx pgx.Tx, user *User) error {
+	sql := "DELETE FROM login_attempt_ WHERE key_ = ANY($1);"
+
+	keys := []string{
+		LoginAttemptEmailKey(user.Email),
+		LoginAttemptTwoFactorKey(user.ID),
+	}
+
+	_, err := tx.Exec(ctx, sql, keys)
+
+	return err
+}
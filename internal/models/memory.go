@@ -0,0 +1,1224 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/web/migrations"
+)
+
+// Session manager's store, e.g. scs.Store
+type sessionFinder interface {
+	Find(token string) (b []byte, found bool, err error)
+	Delete(token string) error
+}
+
+// Create models that keep everything in memory, with the same error
+// semantics as the PostgreSQL models. Revoked sessions are deleted from
+// sessions, which may be nil.
+func NewMemory(sessions sessionFinder) Models {
+	audit := &memoryAuditEventStore{}
+	attempts := &memoryLoginAttemptStore{attempts: make(map[string]*loginAttempt)}
+	index := &memorySessionStore{sessions: sessions, index: make(map[string]*Session)}
+	verifications := &memoryVerificationStore{}
+
+	return Models{
+		APIToken:     &memoryAPITokenStore{tokens: make(map[string]*APIToken)},
+		AuditEvent:   audit,
+		Credential:   &memoryCredentialStore{},
+		Identity:     &memoryIdentityStore{},
+		LoginAttempt: attempts,
+		MailOutbox:   &memoryMailOutboxStore{},
+		Role:         &memoryRoleStore{roles: make(map[uuid.UUID]Roles)},
+		Schema:       memorySchemaStore{},
+		Session:      index,
+		User: &memoryUserStore{
+			users:         make(map[uuid.UUID]*User),
+			deletions:     make(map[uuid.UUID]time.Time),
+			recovery:      make(map[uuid.UUID][][]byte),
+			totpSteps:     make(map[uuid.UUID]int64),
+			verifications: verifications,
+			purgers: []memoryUserPurger{
+				audit.purgeUser,
+				attempts.purgeUser,
+				index.purgeUser,
+				verifications.purgeUser,
+			},
+		},
+		Verification: verifications,
+	}
+}
+
+// Memory equivalent of userPurger
+type memoryUserPurger func(ctx context.Context, user *User) error
+
+type memoryUserStore struct {
+	mu        sync.Mutex
+	users     map[uuid.UUID]*User
+	deletions map[uuid.UUID]time.Time
+	recovery  map[uuid.UUID][][]byte
+	totpSteps map[uuid.UUID]int64
+	// Signup tokens consumed by Register
+	verifications *memoryVerificationStore
+	purgers       []memoryUserPurger
+}
+
+func (m *memoryUserStore) New(ctx context.Context, email, password string) (*User, error) {
+	user, err := newUser(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Like the transaction of UserModel.Register, the token is only consumed
+// if the user can be created.
+func (m *memoryUserStore) Register(ctx context.Context, token, email, password string) (*User, error) {
+	user, err := newUser(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.withEmail(email) != nil {
+		return nil, ErrDuplicateEmail
+	}
+
+	err = m.verifications.Verify(ctx, token, email, PurposeSignup)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.verifications.Purge(ctx, email, PurposeSignup)
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = id
+	user.CreatedAt = time.Now()
+
+	u := *user
+	m.users[id] = &u
+
+	return user, nil
+}
+
+// Get user with email, ignoring case like the citext column. The caller
+// must hold the lock.
+func (m *memoryUserStore) withEmail(email string) *User {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryUserStore) Insert(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.withEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = id
+	user.CreatedAt = time.Now()
+
+	u := *user
+	m.users[id] = &u
+
+	return nil
+}
+
+func (m *memoryUserStore) GetWithID(ctx context.Context, id uuid.UUID) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+
+	c := *u
+
+	return &c, nil
+}
+
+func (m *memoryUserStore) GetWithEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.withEmail(email)
+	if u == nil {
+		return nil, ErrNoRecord
+	}
+
+	c := *u
+
+	return &c, nil
+}
+
+func (m *memoryUserStore) GetAll(ctx context.Context, email string, limit, offset int) ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []*User
+	for _, u := range m.users {
+		if strings.Contains(strings.ToLower(u.Email), strings.ToLower(email)) {
+			c := *u
+			users = append(users, &c)
+		}
+	}
+
+	slices.SortFunc(users, func(a, b *User) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return page(users, limit, offset), nil
+}
+
+func (m *memoryUserStore) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
+	u, err := m.GetWithEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrNoRecord) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, err
+	}
+
+	err = u.checkPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (m *memoryUserStore) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.users[id]
+
+	return ok, nil
+}
+
+func (m *memoryUserStore) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.withEmail(email) != nil, nil
+}
+
+func (m *memoryUserStore) Update(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u := m.withEmail(user.Email); u != nil && u.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	u, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+
+	u.Email = user.Email
+	u.PasswordHash = user.PasswordHash
+
+	return nil
+}
+
+func (m *memoryUserStore) SetDisabled(ctx context.Context, id uuid.UUID, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNoRecord
+	}
+
+	u.DisabledAt = nil
+	if disabled {
+		now := time.Now()
+		u.DisabledAt = &now
+	}
+
+	return nil
+}
+
+func (m *memoryUserStore) ScheduleDeletion(ctx context.Context, id uuid.UUID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; ok {
+		m.deletions[id] = at
+	}
+
+	return nil
+}
+
+func (m *memoryUserStore) CancelDeletion(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.deletions[id]
+	delete(m.deletions, id)
+
+	return ok, nil
+}
+
+func (m *memoryUserStore) PurgeDeleted(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, at := range m.deletions {
+		if at.After(now) {
+			continue
+		}
+
+		// The user is only deleted once all of their data is
+		for _, purge := range m.purgers {
+			err := purge(ctx, m.users[id])
+			if err != nil {
+				return n, err
+			}
+		}
+
+		delete(m.users, id)
+		delete(m.deletions, id)
+		delete(m.recovery, id)
+		delete(m.totpSteps, id)
+		n++
+	}
+
+	return n, nil
+}
+
+func (m *memoryUserStore) EnableTOTP(ctx context.Context, id uuid.UUID, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.TOTPSecret = secret
+	}
+
+	delete(m.totpSteps, id)
+
+	return nil
+}
+
+func (m *memoryUserStore) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.TOTPSecret = ""
+	}
+
+	delete(m.recovery, id)
+	delete(m.totpSteps, id)
+
+	return nil
+}
+
+func (m *memoryUserStore) UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.totpSteps[id]; ok && last >= step {
+		return ErrInvalidCredentials
+	}
+
+	if _, ok := m.users[id]; ok {
+		m.totpSteps[id] = step
+	}
+
+	return nil
+}
+
+func (m *memoryUserStore) NewRecoveryCodes(ctx context.Context, id uuid.UUID) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = recoveryCodeHash(code)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recovery[id] = hashes
+
+	return codes, nil
+}
+
+func (m *memoryUserStore) UseRecoveryCode(ctx context.Context, id uuid.UUID, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := recoveryCodeHash(code)
+
+	i := slices.IndexFunc(m.recovery[id], func(h []byte) bool {
+		return bytes.Equal(h, hash)
+	})
+	if i < 0 {
+		return ErrInvalidCredentials
+	}
+
+	m.recovery[id] = slices.Delete(m.recovery[id], i, i+1)
+
+	return nil
+}
+
+type memoryVerificationStore struct {
+	mu sync.Mutex
+	// Ordered by creation
+	verifications []*Verification
+}
+
+func (m *memoryVerificationStore) New(ctx context.Context, email string, purpose VerificationPurpose) (string, error) {
+	return m.insert(uuid.NullUUID{}, email, purpose)
+}
+
+func (m *memoryVerificationStore) NewForUser(ctx context.Context, userID uuid.UUID, email string, purpose VerificationPurpose) (string, error) {
+	return m.insert(uuid.NullUUID{UUID: userID, Valid: true}, email, purpose)
+}
+
+func (m *memoryVerificationStore) insert(userID uuid.NullUUID, email string, purpose VerificationPurpose) (string, error) {
+	ttl := purpose.TTL()
+	if ttl == 0 {
+		return "", fmt.Errorf("models: unknown verification purpose %q", purpose)
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verifications = append(m.verifications, &Verification{
+		Hash:      tokenHash(token),
+		Email:     email,
+		Purpose:   purpose,
+		UserID:    userID,
+		Expiry:    now.Add(ttl),
+		CreatedAt: now,
+	})
+
+	return token, nil
+}
+
+// Get the most recent verification matching fn.
+func (m *memoryVerificationStore) get(fn func(v *Verification) bool) (*Verification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range slices.Backward(m.verifications) {
+		if fn(v) {
+			c := *v
+
+			return &c, nil
+		}
+	}
+
+	return nil, ErrNoRecord
+}
+
+func (m *memoryVerificationStore) Get(ctx context.Context, email string, purpose VerificationPurpose) (*Verification, error) {
+	return m.get(func(v *Verification) bool {
+		return strings.EqualFold(v.Email, email) && v.Purpose == purpose
+	})
+}
+
+func (m *memoryVerificationStore) GetForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) (*Verification, error) {
+	return m.get(func(v *Verification) bool {
+		return v.UserID.Valid && v.UserID.UUID == userID && v.Purpose == purpose
+	})
+}
+
+func (m *memoryVerificationStore) Verify(ctx context.Context, token, email string, purpose VerificationPurpose) error {
+	hash := tokenHash(token)
+
+	_, err := m.consume(func(v *Verification) bool {
+		return bytes.Equal(v.Hash, hash) && strings.EqualFold(v.Email, email) && v.Purpose == purpose
+	})
+
+	return err
+}
+
+func (m *memoryVerificationStore) Redeem(ctx context.Context, token string, purpose VerificationPurpose) (*Verification, error) {
+	hash := tokenHash(token)
+
+	return m.consume(func(v *Verification) bool {
+		return bytes.Equal(v.Hash, hash) && v.Purpose == purpose
+	})
+}
+
+// Delete the verification matching fn. Expired verifications are deleted
+// too, but return ErrExpiredVerification.
+func (m *memoryVerificationStore) consume(fn func(v *Verification) bool) (*Verification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.verifications, fn)
+	if i < 0 {
+		return nil, ErrNoRecord
+	}
+
+	v := m.verifications[i]
+	m.verifications = slices.Delete(m.verifications, i, i+1)
+
+	if v.IsExpired() {
+		return nil, ErrExpiredVerification
+	}
+
+	return v, nil
+}
+
+func (m *memoryVerificationStore) purge(fn func(v *Verification) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verifications = slices.DeleteFunc(m.verifications, fn)
+}
+
+func (m *memoryVerificationStore) Purge(ctx context.Context, email string, purpose VerificationPurpose) error {
+	m.purge(func(v *Verification) bool {
+		return strings.EqualFold(v.Email, email) && v.Purpose == purpose
+	})
+
+	return nil
+}
+
+func (m *memoryVerificationStore) PurgeForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) error {
+	m.purge(func(v *Verification) bool {
+		return v.UserID.Valid && v.UserID.UUID == userID && v.Purpose == purpose
+	})
+
+	return nil
+}
+
+func (m *memoryVerificationStore) purgeUser(ctx context.Context, user *User) error {
+	m.purge(func(v *Verification) bool {
+		return v.Email == user.Email || (v.UserID.Valid && v.UserID.UUID == user.ID)
+	})
+
+	return nil
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions sessionFinder
+	// Sessions by token
+	index map[string]*Session
+}
+
+func (m *memorySessionStore) Insert(ctx context.Context, s *Session) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	s.ID = id
+	s.CreatedAt = time.Now()
+	s.LastSeenAt = s.CreatedAt
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *s
+	m.index[s.Token] = &c
+
+	return nil
+}
+
+func (m *memorySessionStore) Touch(ctx context.Context, token, ip, userAgent string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.index[token]
+	if ok && time.Since(s.LastSeenAt) > touchInterval {
+		s.LastSeenAt = time.Now()
+		s.IP = ip
+		s.UserAgent = userAgent
+	}
+
+	return nil
+}
+
+// Only indexed sessions are known, so all of them are authenticated.
+func (m *memorySessionStore) Count(ctx context.Context) (total, authenticated int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.index), len(m.index), nil
+}
+
+func (m *memorySessionStore) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []*Session
+	for _, s := range m.index {
+		if s.UserID == userID {
+			c := *s
+			sessions = append(sessions, &c)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *memorySessionStore) DeleteExpired(ctx context.Context) (int, error) {
+	if m.sessions == nil {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for token := range m.index {
+		_, found, err := m.sessions.Find(token)
+		if err != nil {
+			return n, err
+		}
+
+		if !found {
+			delete(m.index, token)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Remove session from the index and the session store. The caller must
+// hold the lock.
+func (m *memorySessionStore) delete(token string) error {
+	delete(m.index, token)
+
+	if m.sessions == nil {
+		return nil
+	}
+
+	return m.sessions.Delete(token)
+}
+
+func (m *memorySessionStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, s := range m.index {
+		if s.UserID == userID && s.ID == id {
+			return m.delete(token)
+		}
+	}
+
+	return ErrNoRecord
+}
+
+func (m *memorySessionStore) DeleteAllForUser(ctx context.Context, userID uuid.UUID, exceptToken string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, s := range m.index {
+		if s.UserID == userID && token != exceptToken {
+			err := m.delete(token)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *memorySessionStore) purgeUser(ctx context.Context, user *User) error {
+	return m.DeleteAllForUser(ctx, user.ID, "")
+}
+
+func (m *memorySessionStore) DeleteWithToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.index, token)
+
+	return nil
+}
+
+type memoryRoleStore struct {
+	mu    sync.Mutex
+	roles map[uuid.UUID]Roles
+}
+
+func (m *memoryRoleStore) GetAllForUser(ctx context.Context, userID uuid.UUID) (Roles, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.roles[userID]), nil
+}
+
+func (m *memoryRoleStore) Grant(ctx context.Context, userID uuid.UUID, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.roles[userID].Has(role) {
+		m.roles[userID] = append(m.roles[userID], role)
+		slices.Sort(m.roles[userID])
+	}
+
+	return nil
+}
+
+func (m *memoryRoleStore) Revoke(ctx context.Context, userID uuid.UUID, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roles[userID] = slices.DeleteFunc(m.roles[userID], func(r Role) bool {
+		return r == role
+	})
+
+	return nil
+}
+
+type memoryAuditEventStore struct {
+	mu sync.Mutex
+	// Ordered by ID
+	events []*AuditEvent
+}
+
+func (m *memoryAuditEventStore) Insert(ctx context.Context, e *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = 1
+	if len(m.events) > 0 {
+		e.ID = m.events[len(m.events)-1].ID + 1
+	}
+	e.CreatedAt = time.Now()
+
+	c := *e
+	m.events = append(m.events, &c)
+
+	return nil
+}
+
+func (m *memoryAuditEventStore) GetAll(ctx context.Context, f AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []*AuditEvent
+	for _, e := range slices.Backward(m.events) {
+		switch {
+		case f.UserID.Valid && e.UserID != f.UserID:
+		case f.Email != "" && !strings.EqualFold(e.Email, f.Email):
+		case f.Type != "" && e.Type != f.Type:
+		default:
+			c := *e
+			events = append(events, &c)
+		}
+	}
+
+	return page(events, limit, offset), nil
+}
+
+type loginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+func (m *memoryAuditEventStore) purgeUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = slices.DeleteFunc(m.events, func(e *AuditEvent) bool {
+		return (e.UserID.Valid && e.UserID.UUID == user.ID) || e.Email == user.Email
+	})
+
+	return nil
+}
+
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempt
+}
+
+func (m *memoryLoginAttemptStore) Check(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if a, ok := m.attempts[key]; ok && a.lockedUntil.After(time.Now()) {
+			return ErrLockedOut
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryLoginAttemptStore) Fail(ctx context.Context, key string, threshold int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	a, ok := m.attempts[key]
+	switch {
+	case !ok:
+		a = &loginAttempt{}
+		m.attempts[key] = a
+	case a.lastFailureAt.Before(now.Add(-loginFailureWindow)):
+		a.failures = 0
+	}
+
+	a.failures++
+	a.lastFailureAt = now
+
+	if a.failures < threshold {
+		return false, nil
+	}
+
+	a.lockedUntil = now.Add(lockoutDuration(a.failures - threshold))
+
+	return a.failures == threshold, nil
+}
+
+func (m *memoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.attempts[key]; !ok {
+		return ErrNoRecord
+	}
+
+	delete(m.attempts, key)
+
+	return nil
+}
+
+func (m *memoryLoginAttemptStore) purgeUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, LoginAttemptEmailKey(user.Email))
+	delete(m.attempts, LoginAttemptTwoFactorKey(user.ID))
+
+	return nil
+}
+
+type memoryMailOutboxStore struct {
+	mu sync.Mutex
+	// Ordered by ID
+	messages []*OutboxMessage
+	lastID   int64
+}
+
+func (m *memoryMailOutboxStore) Enqueue(ctx context.Context, sender string, recipients []string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	now := time.Now()
+	m.messages = append(m.messages, &OutboxMessage{
+		ID:            m.lastID,
+		Sender:        sender,
+		Recipients:    slices.Clone(recipients),
+		Message:       slices.Clone(msg),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*OutboxMessage
+	for _, msg := range m.messages {
+		if msg.FailedAt == nil && !msg.NextAttemptAt.After(now) {
+			due = append(due, msg)
+		}
+	}
+
+	slices.SortStableFunc(due, func(a, b *OutboxMessage) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+
+	claimed := make([]*OutboxMessage, 0, min(limit, len(due)))
+	for _, msg := range page(due, limit, 0) {
+		msg.Attempts++
+		msg.NextAttemptAt = now.Add(lease)
+
+		c := *msg
+		claimed = append(claimed, &c)
+	}
+
+	return claimed, nil
+}
+
+// Update message with id, if there is one.
+func (m *memoryMailOutboxStore) update(id int64, fn func(msg *OutboxMessage)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			fn(msg)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *memoryMailOutboxStore) MarkSent(ctx context.Context, id int64) error {
+	m.delete(func(msg *OutboxMessage) bool {
+		return msg.ID == id
+	})
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) MarkRetry(ctx context.Context, id int64, lastError string, next time.Time) error {
+	m.update(id, func(msg *OutboxMessage) {
+		msg.LastError = lastError
+		msg.NextAttemptAt = next
+	})
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	m.update(id, func(msg *OutboxMessage) {
+		now := time.Now()
+		msg.LastError = lastError
+		msg.FailedAt = &now
+	})
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) GetStuck(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var msgs []*OutboxMessage
+	for _, msg := range slices.Backward(m.messages) {
+		if msg.FailedAt != nil || msg.LastError != "" {
+			c := *msg
+			msgs = append(msgs, &c)
+		}
+	}
+
+	return page(msgs, limit, 0), nil
+}
+
+func (m *memoryMailOutboxStore) Retry(ctx context.Context, id int64) error {
+	ok := m.update(id, func(msg *OutboxMessage) {
+		msg.Attempts = 0
+		msg.FailedAt = nil
+		msg.NextAttemptAt = time.Now()
+	})
+	if !ok {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) Delete(ctx context.Context, id int64) error {
+	n := m.delete(func(msg *OutboxMessage) bool {
+		return msg.ID == id
+	})
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *memoryMailOutboxStore) DeleteFailed(ctx context.Context, before time.Time) (int, error) {
+	return m.delete(func(msg *OutboxMessage) bool {
+		return msg.FailedAt != nil && msg.FailedAt.Before(before)
+	}), nil
+}
+
+// Delete matching messages. Returns the number deleted.
+func (m *memoryMailOutboxStore) delete(fn func(msg *OutboxMessage) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.messages)
+	m.messages = slices.DeleteFunc(m.messages, fn)
+
+	return n - len(m.messages)
+}
+
+type memoryAPITokenStore struct {
+	mu sync.Mutex
+	// By token hash
+	tokens map[string]*APIToken
+}
+
+func (m *memoryAPITokenStore) New(ctx context.Context, userID uuid.UUID, name string, scope APITokenScope, expiry *time.Time) (string, *APIToken, error) {
+	token, err := newAPIToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t := &APIToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Expiry:    expiry,
+		CreatedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens[string(tokenHash(token))] = t
+
+	c := *t
+
+	return token, &c, nil
+}
+
+func (m *memoryAPITokenStore) Authenticate(ctx context.Context, token string) (*APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[string(tokenHash(token))]
+	if !ok || t.IsExpired() {
+		return nil, ErrNoRecord
+	}
+
+	now := time.Now()
+	t.LastUsedAt = &now
+
+	c := *t
+
+	return &c, nil
+}
+
+func (m *memoryAPITokenStore) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []*APIToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			c := *t
+			tokens = append(tokens, &c)
+		}
+	}
+
+	slices.SortFunc(tokens, func(a, b *APIToken) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (m *memoryAPITokenStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, t := range m.tokens {
+		if t.UserID == userID && t.ID == id {
+			delete(m.tokens, hash)
+
+			return nil
+		}
+	}
+
+	return ErrNoRecord
+}
+
+type memoryCredentialStore struct {
+	mu sync.Mutex
+	// Ordered by creation
+	credentials []*Credential
+}
+
+func (m *memoryCredentialStore) Insert(ctx context.Context, c *Credential) error {
+	c.ID = c.Data.ID
+	c.CreatedAt = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cc := *c
+	m.credentials = append(m.credentials, &cc)
+
+	return nil
+}
+
+func (m *memoryCredentialStore) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Credential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var creds []*Credential
+	for _, c := range m.credentials {
+		if c.UserID == userID {
+			cc := *c
+			creds = append(creds, &cc)
+		}
+	}
+
+	return creds, nil
+}
+
+func (m *memoryCredentialStore) UpdateAfterLogin(ctx context.Context, data webauthn.Credential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.credentials {
+		if bytes.Equal(c.ID, data.ID) {
+			now := time.Now()
+			c.Data = data
+			c.LastUsedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryCredentialStore) Delete(ctx context.Context, userID uuid.UUID, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.credentials, func(c *Credential) bool {
+		return c.UserID == userID && bytes.Equal(c.ID, id)
+	})
+	if i < 0 {
+		return ErrNoRecord
+	}
+
+	m.credentials = slices.Delete(m.credentials, i, i+1)
+
+	return nil
+}
+
+type memoryIdentityStore struct {
+	mu         sync.Mutex
+	identities []*Identity
+}
+
+func (m *memoryIdentityStore) Insert(ctx context.Context, i *Identity) error {
+	i.CreatedAt = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *i
+	m.identities = append(m.identities, &c)
+
+	return nil
+}
+
+func (m *memoryIdentityStore) Get(ctx context.Context, issuer, subject string) (*Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			c := *i
+
+			return &c, nil
+		}
+	}
+
+	return nil, ErrNoRecord
+}
+
+// Apply limit and offset to s.
+func page[T any](s []T, limit, offset int) []T {
+	if offset >= len(s) {
+		return nil
+	}
+
+	s = s[offset:]
+	if limit < len(s) {
+		s = s[:limit]
+	}
+
+	return s
+}
+
+// Always at the latest embedded migration, since there is nothing to
+// migrate.
+type memorySchemaStore struct{}
+
+func (memorySchemaStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (memorySchemaStore) Version(ctx context.Context) (uint, bool, error) {
+	version, err := migrations.Latest()
+
+	return version, false, err
+}
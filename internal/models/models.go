@@ -1,23 +1,58 @@
 package models
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const ctxTimeout = 3 * time.Second
 
+// Runs queries with either a pool or a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type Models struct {
-	User         *UserModel
-	Verification *VerificationModel
+	APIToken     APITokenStore
+	AuditEvent   AuditEventStore
+	Credential   CredentialStore
+	Identity     IdentityStore
+	LoginAttempt LoginAttemptStore
+	MailOutbox   MailOutboxStore
+	Role         RoleStore
+	Schema       SchemaStore
+	Session      SessionStore
+	User         UserStore
+	Verification VerificationStore
 }
 
 func New(pool *pgxpool.Pool) Models {
 	return Models{
-		User:         &UserModel{pool},
+		APIToken:     &APITokenModel{pool},
+		AuditEvent:   &AuditEventModel{pool},
+		Credential:   &CredentialModel{pool},
+		Identity:     &IdentityModel{pool},
+		LoginAttempt: &LoginAttemptModel{pool},
+		MailOutbox:   &MailOutboxModel{pool},
+		Role:         &RoleModel{pool},
+		Schema:       &SchemaModel{pool},
+		Session:      &SessionModel{pool},
+		User: &UserModel{
+			pool: pool,
+			purgers: []userPurger{
+				purgeUserAuditEvents,
+				purgeUserLoginAttempts,
+				purgeUserSessions,
+				purgeUserVerifications,
+			},
+		},
 		Verification: &VerificationModel{pool},
 	}
 }
@@ -28,6 +63,8 @@ var (
 	ErrDuplicateEmail      = errors.New("models: duplicate email")
 	ErrExpiredVerification = errors.New("models: expired verification")
 	ErrEditConflict        = errors.New("models: edit conflict")
+	ErrLockedOut           = errors.New("models: locked out")
+	ErrDisabled            = errors.New("models: disabled")
 )
 
 func pgErrCode(err error) string {
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MailOutboxModel struct {
+	pool *pgxpool.Pool
+}
+
+// Rendered message waiting to be sent. Messages are stored as plain
+// text and may contain tokens (e.g. login links), so they are deleted
+// once sent and failed messages are only kept for a limited time.
+type OutboxMessage struct {
+	ID            int64
+	Sender        string
+	Recipients    []string
+	Message       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
+
+const outboxColumns = `id_, sender_, recipients_, message_, attempts_,
+	next_attempt_at_, last_error_, failed_at_, created_at_`
+
+func scanOutboxMessage(row pgx.CollectableRow) (*OutboxMessage, error) {
+	var m OutboxMessage
+	err := row.Scan(
+		&m.ID,
+		&m.Sender,
+		&m.Recipients,
+		&m.Message,
+		&m.Attempts,
+		&m.NextAttemptAt,
+		&m.LastError,
+		&m.FailedAt,
+		&m.CreatedAt)
+
+	return &m, err
+}
+
+func (m *MailOutboxModel) Enqueue(ctx context.Context, sender string, recipients []string, msg []byte) error {
+	sql := `
+		INSERT INTO mail_outbox_ (sender_, recipients_, message_)
+		VALUES($1, $2, $3);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, sender, recipients, msg)
+
+	return err
+}
+
+// Claim up to limit messages that are due at now. Claimed messages are
+// not due again until lease has passed, so a message is retried if the
+// worker that claimed it dies.
+func (m *MailOutboxModel) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	sql := `
+		UPDATE mail_outbox_
+		SET attempts_ = attempts_ + 1, next_attempt_at_ = $1
+		WHERE id_ IN (
+			SELECT id_ FROM mail_outbox_
+			WHERE failed_at_ IS NULL AND next_attempt_at_ <= $2
+			ORDER BY next_attempt_at_
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns + ";"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanOutboxMessage)
+}
+
+// Remove message after it was sent.
+func (m *MailOutboxModel) MarkSent(ctx context.Context, id int64) error {
+	return m.exec(ctx, "DELETE FROM mail_outbox_ WHERE id_ = $1;", id)
+}
+
+// Record failed attempt and schedule the next one.
+func (m *MailOutboxModel) MarkRetry(ctx context.Context, id int64, lastError string, next time.Time) error {
+	sql := `
+		UPDATE mail_outbox_
+		SET last_error_ = $2, next_attempt_at_ = $3
+		WHERE id_ = $1;`
+
+	return m.exec(ctx, sql, id, lastError, next)
+}
+
+// Record that the last attempt failed. The message is not sent again
+// unless retried.
+func (m *MailOutboxModel) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	sql := `
+		UPDATE mail_outbox_
+		SET last_error_ = $2, failed_at_ = NOW()
+		WHERE id_ = $1;`
+
+	return m.exec(ctx, sql, id, lastError)
+}
+
+// Get failed messages, and messages that have failed at least once and
+// are waiting to be retried.
+func (m *MailOutboxModel) GetStuck(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	sql := `
+		SELECT ` + outboxColumns + `
+		FROM mail_outbox_
+		WHERE failed_at_ IS NOT NULL OR last_error_ <> ''
+		ORDER BY created_at_ DESC
+		LIMIT $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanOutboxMessage)
+}
+
+// Reset attempts of message so that it is sent again right away.
+func (m *MailOutboxModel) Retry(ctx context.Context, id int64) error {
+	sql := `
+		UPDATE mail_outbox_
+		SET attempts_ = 0, failed_at_ = NULL, next_attempt_at_ = NOW()
+		WHERE id_ = $1;`
+
+	return m.execOne(ctx, sql, id)
+}
+
+func (m *MailOutboxModel) Delete(ctx context.Context, id int64) error {
+	return m.execOne(ctx, "DELETE FROM mail_outbox_ WHERE id_ = $1;", id)
+}
+
+// Delete messages that failed before time. Returns the number deleted.
+func (m *MailOutboxModel) DeleteFailed(ctx context.Context, before time.Time) (int, error) {
+	sql := "DELETE FROM mail_outbox_ WHERE failed_at_ < $1;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (m *MailOutboxModel) exec(ctx context.Context, sql string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, args...)
+
+	return err
+}
+
+// Exec statement that must affect a row
+func (m *MailOutboxModel) execOne(ctx context.Context, sql string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Role string
+
+const (
+	RoleAdmin     = Role("admin")
+	RoleModerator = Role("moderator")
+)
+
+type Permission string
+
+const (
+	PermissionViewUsers    = Permission("users:view")
+	PermissionDisableUsers = Permission("users:disable")
+	PermissionManageMail   = Permission("mail:manage")
+)
+
+// Permissions granted by each role
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionViewUsers,
+		PermissionDisableUsers,
+		PermissionManageMail,
+	},
+	RoleModerator: {
+		PermissionViewUsers,
+	},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+
+	return ok
+}
+
+// Roles of a user
+type Roles []Role
+
+func (rs Roles) Has(role Role) bool {
+	return slices.Contains(rs, role)
+}
+
+// Check if any of the roles grants permission.
+func (rs Roles) Can(p Permission) bool {
+	for _, r := range rs {
+		if slices.Contains(rolePermissions[r], p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type RoleModel struct {
+	pool *pgxpool.Pool
+}
+
+func (m *RoleModel) GetAllForUser(ctx context.Context, userID uuid.UUID) (Roles, error) {
+	sql := "SELECT role_ FROM user_role_ WHERE user_id_ = $1 ORDER BY role_;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[Role])
+}
+
+func (m *RoleModel) Grant(ctx context.Context, userID uuid.UUID, role Role) error {
+	sql := `
+		INSERT INTO user_role_ (user_id_, role_)
+		VALUES($1, $2)
+		ON CONFLICT DO NOTHING;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, userID, role)
+
+	return err
+}
+
+func (m *RoleModel) Revoke(ctx context.Context, userID uuid.UUID, role Role) error {
+	sql := "DELETE FROM user_role_ WHERE user_id_ = $1 AND role_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, userID, role)
+
+	return err
+}
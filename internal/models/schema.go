@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Database schema state, as recorded by golang-migrate
+type SchemaModel struct {
+	pool *pgxpool.Pool
+}
+
+func (m *SchemaModel) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Get the applied migration version, and whether the last migration
+// failed part way.
+func (m *SchemaModel) Version(ctx context.Context) (uint, bool, error) {
+	sql := "SELECT version, dirty FROM schema_migrations LIMIT 1;"
+
+	var version int64
+	var dirty bool
+	err := m.pool.QueryRow(ctx, sql).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, ErrNoRecord
+	}
+
+	return uint(version), dirty, err
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Minimum time between updates of a session's last seen time
+const touchInterval = time.Minute
+
+// Indexes the scs sessions table by user, so that users can see and
+// revoke their sessions.
+type SessionModel struct {
+	pool *pgxpool.Pool
+}
+
+type Session struct {
+	ID         uuid.UUID
+	Token      string
+	UserID     uuid.UUID
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+func scanSession(row pgx.CollectableRow) (*Session, error) {
+	var s Session
+	err := row.Scan(
+		&s.ID,
+		&s.Token,
+		&s.UserID,
+		&s.IP,
+		&s.UserAgent,
+		&s.CreatedAt,
+		&s.LastSeenAt)
+
+	return &s, err
+}
+
+func (m *SessionModel) Insert(ctx context.Context, s *Session) error {
+	sql := `
+		INSERT INTO user_session_ (token_, user_id_, ip_, user_agent_)
+		VALUES($1, $2, $3, $4)
+		RETURNING id_, created_at_, last_seen_at_;`
+
+	args := []any{s.Token, s.UserID, s.IP, s.UserAgent}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.pool.QueryRow(ctx, sql, args...).Scan(&s.ID, &s.CreatedAt, &s.LastSeenAt)
+}
+
+// Update last seen time, IP and user agent of session with token.
+func (m *SessionModel) Touch(ctx context.Context, token, ip, userAgent string) error {
+	sql := `
+		UPDATE user_session_
+		SET last_seen_at_ = NOW(), ip_ = $2, user_agent_ = $3
+		WHERE token_ = $1 AND last_seen_at_ < NOW() - $4::interval;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, token, ip, userAgent, touchInterval)
+
+	return err
+}
+
+// Count unexpired sessions in the session store, and how many of them
+// belong to an authenticated user.
+func (m *SessionModel) Count(ctx context.Context) (total, authenticated int, err error) {
+	sql := `
+		SELECT COUNT(*), COUNT(us.id_)
+		FROM sessions s
+		LEFT JOIN user_session_ us ON us.token_ = s.token
+		WHERE s.expiry > NOW();`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = m.pool.QueryRow(ctx, sql).Scan(&total, &authenticated)
+
+	return total, authenticated, err
+}
+
+// Get all unexpired sessions of user, most recently seen first.
+func (m *SessionModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Session, error) {
+	sql := `
+		SELECT us.id_, us.token_, us.user_id_, us.ip_, us.user_agent_,
+			us.created_at_, us.last_seen_at_
+		FROM user_session_ us
+		JOIN sessions s ON s.token = us.token_
+		WHERE us.user_id_ = $1 AND s.expiry > NOW()
+		ORDER BY us.last_seen_at_ DESC;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanSession)
+}
+
+// Revoke session of user with id. Returns ErrNoRecord if the user has
+// no such session.
+func (m *SessionModel) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		var token string
+
+		sql := `
+			DELETE FROM user_session_
+			WHERE user_id_ = $1 AND id_ = $2
+			RETURNING token_;`
+
+		err := tx.QueryRow(ctx, sql, userID, id).Scan(&token)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNoRecord
+			}
+
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM sessions WHERE token = $1;", token)
+
+		return err
+	})
+}
+
+// Revoke all sessions of user except the one with token.
+func (m *SessionModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID, exceptToken string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		sql := `
+			DELETE FROM sessions
+			WHERE token IN (
+				SELECT token_
+				FROM user_session_
+				WHERE user_id_ = $1 AND token_ <> $2
+			);`
+
+		_, err := tx.Exec(ctx, sql, userID, exceptToken)
+		if err != nil {
+			return err
+		}
+
+		sql = "DELETE FROM user_session_ WHERE user_id_ = $1 AND token_ <> $2;"
+
+		_, err = tx.Exec(ctx, sql, userID, exceptToken)
+
+		return err
+	})
+}
+
+// Remove session with token from the index, e.g. on logout.
+func (m *SessionModel) DeleteWithToken(ctx context.Context, token string) error {
+	sql := "DELETE FROM user_session_ WHERE token_ = $1;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, token)
+
+	return err
+}
+
+// Remove sessions from the index that have expired or are gone from the
+// session store. Returns the number of sessions removed.
+func (m *SessionModel) DeleteExpired(ctx context.Context) (int, error) {
+	sql := `
+		DELETE FROM user_session_ us
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM sessions s
+			WHERE s.token = us.token_ AND s.expiry > NOW()
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func purgeUserSessions(ctx context.Context, tx pgx.Tx, user *User) error {
+	sql := `
+		DELETE FROM sessions
+		WHERE token IN (
+			SELECT token_
+			FROM user_session_
+			WHERE user_id_ = $1
+		);`
+
+	_, err := tx.Exec(ctx, sql, user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM user_session_ WHERE user_id_ = $1;", user.ID)
+
+	return err
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Stores used by the auth flows. The Model types implement them with
+// PostgreSQL, and NewMemory returns implementations that keep everything
+// in memory, so handlers can be tested without a database.
+
+type UserStore interface {
+	New(ctx context.Context, email, password string) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	Register(ctx context.Context, token, email, password string) (*User, error)
+	GetWithID(ctx context.Context, id uuid.UUID) (*User, error)
+	GetWithEmail(ctx context.Context, email string) (*User, error)
+	GetAll(ctx context.Context, email string, limit, offset int) ([]*User, error)
+	GetForCredentials(ctx context.Context, email, password string) (*User, error)
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+	ExistsWithEmail(ctx context.Context, email string) (bool, error)
+	Update(ctx context.Context, user *User) error
+	SetDisabled(ctx context.Context, id uuid.UUID, disabled bool) error
+	ScheduleDeletion(ctx context.Context, id uuid.UUID, at time.Time) error
+	CancelDeletion(ctx context.Context, id uuid.UUID) (bool, error)
+	PurgeDeleted(ctx context.Context, now time.Time) (int, error)
+	EnableTOTP(ctx context.Context, id uuid.UUID, secret string) error
+	DisableTOTP(ctx context.Context, id uuid.UUID) error
+	UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) error
+	NewRecoveryCodes(ctx context.Context, id uuid.UUID) ([]string, error)
+	UseRecoveryCode(ctx context.Context, id uuid.UUID, code string) error
+}
+
+type VerificationStore interface {
+	New(ctx context.Context, email string, purpose VerificationPurpose) (string, error)
+	NewForUser(ctx context.Context, userID uuid.UUID, email string, purpose VerificationPurpose) (string, error)
+	Get(ctx context.Context, email string, purpose VerificationPurpose) (*Verification, error)
+	GetForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) (*Verification, error)
+	Verify(ctx context.Context, token, email string, purpose VerificationPurpose) error
+	Redeem(ctx context.Context, token string, purpose VerificationPurpose) (*Verification, error)
+	Purge(ctx context.Context, email string, purpose VerificationPurpose) error
+	PurgeForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) error
+}
+
+type APITokenStore interface {
+	New(ctx context.Context, userID uuid.UUID, name string, scope APITokenScope, expiry *time.Time) (string, *APIToken, error)
+	Authenticate(ctx context.Context, token string) (*APIToken, error)
+	GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*APIToken, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+}
+
+type CredentialStore interface {
+	Insert(ctx context.Context, c *Credential) error
+	GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Credential, error)
+	UpdateAfterLogin(ctx context.Context, data webauthn.Credential) error
+	Delete(ctx context.Context, userID uuid.UUID, id []byte) error
+}
+
+type IdentityStore interface {
+	Insert(ctx context.Context, i *Identity) error
+	Get(ctx context.Context, issuer, subject string) (*Identity, error)
+}
+
+type SessionStore interface {
+	Insert(ctx context.Context, s *Session) error
+	Touch(ctx context.Context, token, ip, userAgent string) error
+	Count(ctx context.Context) (total, authenticated int, err error)
+	GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*Session, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID, exceptToken string) error
+	DeleteWithToken(ctx context.Context, token string) error
+	DeleteExpired(ctx context.Context) (int, error)
+}
+
+type SchemaStore interface {
+	Ping(ctx context.Context) error
+	Version(ctx context.Context) (uint, bool, error)
+}
+
+type RoleStore interface {
+	GetAllForUser(ctx context.Context, userID uuid.UUID) (Roles, error)
+	Grant(ctx context.Context, userID uuid.UUID, role Role) error
+	Revoke(ctx context.Context, userID uuid.UUID, role Role) error
+}
+
+type AuditEventStore interface {
+	Insert(ctx context.Context, e *AuditEvent) error
+	GetAll(ctx context.Context, f AuditFilter, limit, offset int) ([]*AuditEvent, error)
+}
+
+type MailOutboxStore interface {
+	Enqueue(ctx context.Context, sender string, recipients []string, msg []byte) error
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*OutboxMessage, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkRetry(ctx context.Context, id int64, lastError string, next time.Time) error
+	MarkFailed(ctx context.Context, id int64, lastError string) error
+	GetStuck(ctx context.Context, limit int) ([]*OutboxMessage, error)
+	Retry(ctx context.Context, id int64) error
+	Delete(ctx context.Context, id int64) error
+	DeleteFailed(ctx context.Context, before time.Time) (int, error)
+}
+
+type LoginAttemptStore interface {
+	Check(ctx context.Context, keys ...string) error
+	Fail(ctx context.Context, key string, threshold int) (bool, error)
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const recoveryCodeCount = 10
+
+// Check if user has enabled TOTP two-factor authentication.
+func (u User) HasTOTP() bool {
+	return u.TOTPSecret != ""
+}
+
+// Enable TOTP for user with a confirmed secret.
+func (m *UserModel) EnableTOTP(ctx context.Context, id uuid.UUID, secret string) error {
+	sql := "UPDATE user_ SET totp_secret_ = $1, totp_last_step_ = NULL WHERE id_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, secret, id)
+
+	return err
+}
+
+// Disable TOTP for user and delete their recovery codes.
+func (m *UserModel) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE user_ SET totp_secret_ = NULL, totp_last_step_ = NULL WHERE id_ = $1;", id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM recovery_code_ WHERE user_id_ = $1;", id)
+
+		return err
+	})
+}
+
+// Record that user has used a TOTP code of time step. Returns
+// ErrInvalidCredentials if a code of the same or a later step has
+// already been used, so each code is only accepted once.
+func (m *UserModel) UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) error {
+	sql := `
+		UPDATE user_
+		SET totp_last_step_ = $1
+		WHERE id_ = $2 AND (totp_last_step_ IS NULL OR totp_last_step_ < $1);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, step, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Replace all recovery codes of user with a new set. Only the hashes
+// are stored, so the returned codes can't be shown again.
+func (m *UserModel) NewRecoveryCodes(ctx context.Context, id uuid.UUID) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "DELETE FROM recovery_code_ WHERE user_id_ = $1;", id)
+		if err != nil {
+			return err
+		}
+
+		sql := "INSERT INTO recovery_code_ (hash_, user_id_) VALUES($1, $2);"
+		for _, code := range codes {
+			_, err = tx.Exec(ctx, sql, recoveryCodeHash(code), id)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Consume recovery code of user. Returns ErrInvalidCredentials if the
+// code does not exist or has already been used.
+func (m *UserModel) UseRecoveryCode(ctx context.Context, id uuid.UUID, code string) error {
+	sql := "DELETE FROM recovery_code_ WHERE user_id_ = $1 AND hash_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, id, recoveryCodeHash(code))
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	return codes, nil
+}
+
+// Hash recovery code ignoring case, spaces and dashes.
+func recoveryCodeHash(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return tokenHash(code)
+}
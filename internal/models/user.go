@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/argon2id"
@@ -13,7 +14,8 @@ import (
 )
 
 type UserModel struct {
-	pool *pgxpool.Pool
+	pool    *pgxpool.Pool
+	purgers []userPurger
 }
 
 type User struct {
@@ -21,12 +23,39 @@ type User struct {
 	CreatedAt    time.Time
 	Email        string
 	PasswordHash []byte
+	TOTPSecret   string
+	DisabledAt   *time.Time
+}
+
+// Columns scanned by scanUser
+const userColumns = `id_, created_at_, email_, password_hash_,
+	COALESCE(totp_secret_, ''), disabled_at_`
+
+func scanUser(row pgx.CollectableRow) (*User, error) {
+	var u User
+	err := row.Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.TOTPSecret,
+		&u.DisabledAt)
+
+	return &u, err
 }
 
 func (u User) Validate() error {
 	return nil
 }
 
+func (u User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+func (u User) HasPassword() bool {
+	return len(u.PasswordHash) > 0
+}
+
 func (u *User) SetPasswordHash(password string) error {
 	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
 	if err != nil {
@@ -38,15 +67,33 @@ func (u *User) SetPasswordHash(password string) error {
 	return nil
 }
 
-func (m *UserModel) New(email, password string) (*User, error) {
-	user := &User{Email: email}
+// Create new user. An empty password creates a user that can only
+// login without one, e.g. with an external identity.
+func (m *UserModel) New(ctx context.Context, email, password string) (*User, error) {
+	user, err := newUser(email, password)
+	if err != nil {
+		return nil, err
+	}
 
-	err := user.SetPasswordHash(password)
+	err = m.Insert(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(user)
+	return user, nil
+}
+
+func newUser(email, password string) (*User, error) {
+	user := &User{Email: email}
+
+	if password != "" {
+		err := user.SetPasswordHash(password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := user.Validate()
 	if err != nil {
 		return nil, err
 	}
@@ -54,12 +101,19 @@ func (m *UserModel) New(email, password string) (*User, error) {
 	return user, nil
 }
 
-func (m *UserModel) Insert(user *User) error {
+func (m *UserModel) Insert(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return insertUser(ctx, m.pool, user)
+}
+
+func insertUser(ctx context.Context, q querier, user *User) error {
 	sql := `
 		INSERT INTO user_ (email_, password_hash_)
 		VALUES($1, $2)
@@ -67,10 +121,7 @@ func (m *UserModel) Insert(user *User) error {
 
 	args := []any{user.Email, user.PasswordHash}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
-	defer cancel()
-
-	err = m.pool.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt)
+	err := q.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt)
 	if err != nil {
 		switch {
 		case pgErrCode(err) == pgerrcode.UniqueViolation:
@@ -83,50 +134,64 @@ func (m *UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m *UserModel) GetWithID(id uuid.UUID) (*User, error) {
-	var u User
-
-	sql := `
-		SELECT id_, created_at_, email_, password_hash_
-		FROM user_ WHERE id_ = $1;`
+// Create new user with a signup token for email. The token is consumed
+// in the same transaction, so it stays valid if the user can't be created.
+// Other signup tokens for email are deleted.
+func (m *UserModel) Register(ctx context.Context, token, email, password string) (*User, error) {
+	user, err := newUser(email, password)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, id).Scan(
-		&u.ID,
-		&u.CreatedAt,
-		&u.Email,
-		&u.PasswordHash,
-	)
-	if err != nil {
-		switch {
-		case errors.Is(err, pgx.ErrNoRows):
-			return nil, ErrNoRecord
-		default:
-			return nil, err
+	err = pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		err := verify(ctx, tx, token, email, PurposeSignup)
+		if err != nil {
+			return err
+		}
+
+		err = insertUser(ctx, tx, user)
+		if err != nil {
+			return err
 		}
+
+		sql := "DELETE FROM verification_ WHERE email_ = $1 AND purpose_ = $2;"
+
+		_, err = tx.Exec(ctx, sql, email, PurposeSignup)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &u, nil
+	return user, nil
 }
 
-func (m *UserModel) GetWithEmail(email string) (*User, error) {
-	var u User
+func (m *UserModel) GetWithID(ctx context.Context, id uuid.UUID) (*User, error) {
+	sql := "SELECT " + userColumns + " FROM user_ WHERE id_ = $1;"
 
-	sql := `
-		SELECT id_, created_at_, email_, password_hash_
-		FROM user_ WHERE email_ = $1;`
+	return m.get(ctx, sql, id)
+}
+
+func (m *UserModel) GetWithEmail(ctx context.Context, email string) (*User, error) {
+	sql := "SELECT " + userColumns + " FROM user_ WHERE email_ = $1;"
+
+	return m.get(ctx, sql, email)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (m *UserModel) get(ctx context.Context, sql string, args ...any) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(
-		&u.ID,
-		&u.CreatedAt,
-		&u.Email,
-		&u.PasswordHash,
-	)
+	rows, err := m.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := pgx.CollectOneRow(rows, scanUser)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -136,11 +201,36 @@ func (m *UserModel) GetWithEmail(email string) (*User, error) {
 		}
 	}
 
-	return &u, nil
+	return u, nil
+}
+
+// Get page of users ordered by creation, optionally filtered by email.
+func (m *UserModel) GetAll(ctx context.Context, email string, limit, offset int) ([]*User, error) {
+	sql := "SELECT " + userColumns + ` FROM user_
+		WHERE ($1 = '' OR email_ ILIKE '%' || $1 || '%' ESCAPE '\')
+		ORDER BY created_at_
+		LIMIT $2 OFFSET $3;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, escapeLike(email), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanUser)
 }
 
-func (m *UserModel) GetForCredentials(email, password string) (*User, error) {
-	u, err := m.GetWithEmail(email)
+// Escape the wildcards of LIKE patterns, so s matches literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (m *UserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
+	u, err := m.GetWithEmail(ctx, email)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrNoRecord):
@@ -150,18 +240,38 @@ func (m *UserModel) GetForCredentials(email, password string) (*User, error) {
 		}
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+	err = u.checkPassword(password)
 	if err != nil {
 		return nil, err
 	}
+
+	return u, nil
+}
+
+// Check password of user trying to login. Returns ErrInvalidCredentials
+// if it doesn't match, or ErrDisabled if the user is disabled.
+func (u *User) checkPassword(password string) error {
+	// Users without a password can't login with one
+	if !u.HasPassword() {
+		return ErrInvalidCredentials
+	}
+
+	match, err := argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+	if err != nil {
+		return err
+	}
 	if !match {
-		return nil, ErrInvalidCredentials
+		return ErrInvalidCredentials
 	}
 
-	return u, nil
+	if u.IsDisabled() {
+		return ErrDisabled
+	}
+
+	return nil
 }
 
-func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
 	var exists bool
 
 	sql := `
@@ -171,7 +281,7 @@ func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
 			WHERE id_ = $1
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
 	err := m.pool.QueryRow(ctx, sql, id).Scan(&exists)
@@ -182,7 +292,7 @@ func (m *UserModel) Exists(id uuid.UUID) (bool, error) {
 	return exists, nil
 }
 
-func (m *UserModel) ExistsWithEmail(email string) (bool, error) {
+func (m *UserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
 	var exists bool
 
 	sql := `
@@ -192,7 +302,7 @@ func (m *UserModel) ExistsWithEmail(email string) (bool, error) {
 			WHERE email_ = $1
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
 	err := m.pool.QueryRow(ctx, sql, email).Scan(&exists)
@@ -203,7 +313,7 @@ func (m *UserModel) ExistsWithEmail(email string) (bool, error) {
 	return exists, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
@@ -220,7 +330,7 @@ func (m UserModel) Update(user *User) error {
 		user.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
 	_, err = m.pool.Exec(ctx, sql, args...)
@@ -237,3 +347,25 @@ func (m UserModel) Update(user *User) error {
 
 	return nil
 }
+
+// Disable or re-enable user. Disabled users can't login.
+func (m *UserModel) SetDisabled(ctx context.Context, id uuid.UUID, disabled bool) error {
+	sql := `
+		UPDATE user_
+		SET disabled_at_ = CASE WHEN $1 THEN NOW() END
+		WHERE id_ = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tag, err := m.pool.Exec(ctx, sql, disabled, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -6,16 +6,39 @@ import (
 	"crypto/sha256"
 	"encoding/base32"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Flow that a verification token was created for. A token is only
+// accepted by the flow with the same purpose.
+type VerificationPurpose string
+
 const (
-	ttl = time.Hour
+	PurposeSignup            = VerificationPurpose("signup")
+	PurposeReset             = VerificationPurpose("reset")
+	PurposeEmailChange       = VerificationPurpose("email_change")
+	PurposeEmailChangeCancel = VerificationPurpose("email_change_cancel")
+	PurposeMagicLogin        = VerificationPurpose("magic_login")
 )
 
+// Time to live of tokens for each purpose
+var verificationTTLs = map[VerificationPurpose]time.Duration{
+	PurposeSignup:            24 * time.Hour,
+	PurposeReset:             time.Hour,
+	PurposeEmailChange:       time.Hour,
+	PurposeEmailChangeCancel: 7 * 24 * time.Hour,
+	PurposeMagicLogin:        15 * time.Minute,
+}
+
+func (p VerificationPurpose) TTL() time.Duration {
+	return verificationTTLs[p]
+}
+
 type VerificationModel struct {
 	pool *pgxpool.Pool
 }
@@ -23,6 +46,8 @@ type VerificationModel struct {
 type Verification struct {
 	Hash      []byte
 	Email     string
+	Purpose   VerificationPurpose
+	UserID    uuid.NullUUID
 	Expiry    time.Time
 	CreatedAt time.Time
 }
@@ -36,40 +61,81 @@ func scanVerification(row pgx.CollectableRow) (*Verification, error) {
 	err := row.Scan(
 		&v.Hash,
 		&v.Email,
+		&v.Purpose,
+		&v.UserID,
 		&v.Expiry,
 		&v.CreatedAt)
 
 	return &v, err
 }
 
-// Create new verification token. Store hash in database and return token.
-func (m *VerificationModel) New(email string) (string, error) {
-	expiry := time.Now().Add(ttl)
+func tokenHash(token string) []byte {
+	// Note: sum is a byte array, while hash is a slice
+	sum := sha256.Sum256([]byte(token))
 
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
+	return sum[:]
+}
+
+// Create new verification token for purpose. Store hash in database
+// and return token.
+func (m *VerificationModel) New(ctx context.Context, email string, purpose VerificationPurpose) (string, error) {
+	return m.insert(ctx, uuid.NullUUID{}, email, purpose)
+}
+
+// Create new verification token for purpose that belongs to an existing
+// user, e.g. to confirm a change to their account.
+func (m *VerificationModel) NewForUser(ctx context.Context, userID uuid.UUID, email string, purpose VerificationPurpose) (string, error) {
+	return m.insert(ctx, uuid.NullUUID{UUID: userID, Valid: true}, email, purpose)
+}
+
+func (m *VerificationModel) insert(ctx context.Context, userID uuid.NullUUID, email string, purpose VerificationPurpose) (string, error) {
+	ttl := purpose.TTL()
+	if ttl == 0 {
+		return "", fmt.Errorf("models: unknown verification purpose %q", purpose)
+	}
+
+	token, err := newVerificationToken()
 	if err != nil {
 		return "", err
 	}
 
-	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
-	// Note: sum is a byte array, while hash is a slice
-	sum := sha256.Sum256([]byte(token))
-	hash := sum[:]
-
 	sql := `INSERT INTO verification_
-		(hash_, email_, expiry_)
-		VALUES($1, $2, $3);`
+		(hash_, email_, purpose_, user_id_, expiry_)
+		VALUES($1, $2, $3, $4, $5);`
+
+	args := []any{tokenHash(token), email, purpose, userID, time.Now().Add(ttl)}
 
-	_, err = m.pool.Exec(context.Background(), sql, hash, email, expiry)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.pool.Exec(ctx, sql, args...)
 
 	return token, err
 }
 
-func (m *VerificationModel) Get(email string) (*Verification, error) {
-	sql := "SELECT * FROM verification_ WHERE email_ = $1;"
+func newVerificationToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
 
-	rows, err := m.pool.Query(context.Background(), sql, email)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// Get the most recent verification for email and purpose.
+func (m *VerificationModel) Get(ctx context.Context, email string, purpose VerificationPurpose) (*Verification, error) {
+	sql := `
+		SELECT hash_, email_, purpose_, user_id_, expiry_, created_at_
+		FROM verification_
+		WHERE email_ = $1 AND purpose_ = $2
+		ORDER BY created_at_ DESC
+		LIMIT 1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.pool.Query(ctx, sql, email, purpose)
 	if err != nil {
 		return nil, err
 	}
@@ -82,34 +148,117 @@ func (m *VerificationModel) Get(email string) (*Verification, error) {
 	return v, err
 }
 
-func (m *VerificationModel) Verify(token, email string) error {
-	sum := sha256.Sum256([]byte(token))
-	hash := sum[:]
+// Get the most recent verification of user for purpose.
+func (m *VerificationModel) GetForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) (*Verification, error) {
+	sql := `
+		SELECT hash_, email_, purpose_, user_id_, expiry_, created_at_
+		FROM verification_
+		WHERE user_id_ = $1 AND purpose_ = $2
+		ORDER BY created_at_ DESC
+		LIMIT 1;`
 
-	sql := `SELECT * FROM verification_ 
-		WHERE hash_ = $1 AND email_ = $2;`
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
 
-	rows, err := m.pool.Query(context.Background(), sql, hash, email)
+	rows, err := m.pool.Query(ctx, sql, userID, purpose)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	v, err := pgx.CollectOneRow(rows, scanVerification)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return ErrNoRecord
+		return nil, ErrNoRecord
+	}
+
+	return v, err
+}
+
+// Verify and consume token. The token is deleted in the same statement
+// that matches it, so it can only ever be used once.
+func (m *VerificationModel) Verify(ctx context.Context, token, email string, purpose VerificationPurpose) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return verify(ctx, m.pool, token, email, purpose)
+}
+
+func verify(ctx context.Context, q querier, token, email string, purpose VerificationPurpose) error {
+	sql := `
+		DELETE FROM verification_
+		WHERE hash_ = $1 AND email_ = $2 AND purpose_ = $3
+		RETURNING hash_, email_, purpose_, user_id_, expiry_, created_at_;`
+
+	_, err := consumeVerification(ctx, q, sql, tokenHash(token), email, purpose)
+
+	return err
+}
+
+// Consume token without knowing the email it was created for, for links
+// that have to work outside of the session that requested them. Returns
+// the consumed verification.
+func (m *VerificationModel) Redeem(ctx context.Context, token string, purpose VerificationPurpose) (*Verification, error) {
+	sql := `
+		DELETE FROM verification_
+		WHERE hash_ = $1 AND purpose_ = $2
+		RETURNING hash_, email_, purpose_, user_id_, expiry_, created_at_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return consumeVerification(ctx, m.pool, sql, tokenHash(token), purpose)
+}
+
+func consumeVerification(ctx context.Context, q querier, sql string, args ...any) (*Verification, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := pgx.CollectOneRow(rows, scanVerification)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
 	}
 
 	if v.IsExpired() {
-		return ErrExpiredVerification
+		return nil, ErrExpiredVerification
 	}
 
-	return nil
+	return v, nil
+}
+
+// Delete all verifications for email and purpose.
+func (m *VerificationModel) Purge(ctx context.Context, email string, purpose VerificationPurpose) error {
+	sql := "DELETE FROM verification_ WHERE email_ = $1 AND purpose_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, email, purpose)
+
+	return err
+}
+
+// Delete all verifications of user for purpose.
+func (m *VerificationModel) PurgeForUser(ctx context.Context, userID uuid.UUID, purpose VerificationPurpose) error {
+	sql := "DELETE FROM verification_ WHERE user_id_ = $1 AND purpose_ = $2;"
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.pool.Exec(ctx, sql, userID, purpose)
+
+	return err
 }
 
-func (m *VerificationModel) Purge(email string) error {
-	sql := "DELETE FROM verification_ WHERE email_ = $1;"
+func purgeUserVerifications(ctx context.Context, tx pgx.Tx, user *User) error {
+	sql := "DELETE FROM verification_ WHERE email_ = $1 OR user_id_ = $2;"
 
-	_, err := m.pool.Exec(context.Background(), sql, email)
+	_, err := tx.Exec(ctx, sql, user.Email, user.ID)
 
 	return err
 }
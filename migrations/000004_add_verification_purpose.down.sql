@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS verification_email_purpose_idx;
+ALTER TABLE verification_ DROP COLUMN IF EXISTS purpose_;
@@ -0,0 +1,6 @@
+-- Existing tokens cannot be attributed to a flow, so drop them.
+DELETE FROM verification_;
+
+ALTER TABLE verification_ ADD COLUMN purpose_ TEXT NOT NULL;
+
+CREATE INDEX verification_email_purpose_idx ON verification_ (email_, purpose_);
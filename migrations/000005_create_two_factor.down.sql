@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS recovery_code_user_id_idx;
+DROP TABLE IF EXISTS recovery_code_;
+ALTER TABLE user_ DROP COLUMN IF EXISTS totp_secret_;
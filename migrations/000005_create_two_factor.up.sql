@@ -0,0 +1,9 @@
+ALTER TABLE user_ ADD COLUMN totp_secret_ TEXT;
+
+CREATE TABLE IF NOT EXISTS recovery_code_ (
+    hash_ BYTEA PRIMARY KEY,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX recovery_code_user_id_idx ON recovery_code_ (user_id_);
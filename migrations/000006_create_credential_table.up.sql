@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS credential_ (
+    id_ BYTEA PRIMARY KEY,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    data_ JSONB NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at_ TIMESTAMPTZ
+);
+
+CREATE INDEX credential_user_id_idx ON credential_ (user_id_);
@@ -0,0 +1,13 @@
+-- Users without a password can't be kept once one is required again, and
+-- deleting them would lose their data, so refuse to roll back instead.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_ WHERE password_hash_ IS NULL) THEN
+        RAISE EXCEPTION 'users without a password exist, set or delete them before rolling back';
+    END IF;
+END
+$$;
+
+DROP INDEX IF EXISTS identity_user_id_idx;
+DROP TABLE IF EXISTS identity_;
+ALTER TABLE user_ ALTER COLUMN password_hash_ SET NOT NULL;
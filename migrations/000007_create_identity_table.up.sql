@@ -0,0 +1,13 @@
+-- Users that signed up with an external identity have no password.
+ALTER TABLE user_ ALTER COLUMN password_hash_ DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS identity_ (
+    issuer_ TEXT NOT NULL,
+    subject_ TEXT NOT NULL,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    email_ CITEXT NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer_, subject_)
+);
+
+CREATE INDEX identity_user_id_idx ON identity_ (user_id_);
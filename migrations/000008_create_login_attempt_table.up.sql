@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS login_attempt_ (
+    key_ TEXT PRIMARY KEY,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until_ TIMESTAMPTZ
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_session_user_id_idx;
+DROP TABLE IF EXISTS user_session_;
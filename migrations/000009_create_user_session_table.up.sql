@@ -0,0 +1,13 @@
+-- Index of the sessions that belong to each user. Rows are removed
+-- together with the matching row in the sessions table.
+CREATE TABLE IF NOT EXISTS user_session_ (
+    id_ uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+    token_ TEXT UNIQUE NOT NULL,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    ip_ TEXT NOT NULL,
+    user_agent_ TEXT NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX user_session_user_id_idx ON user_session_ (user_id_);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS verification_user_id_idx;
+ALTER TABLE verification_ DROP COLUMN IF EXISTS user_id_;
@@ -0,0 +1,4 @@
+-- Verifications for changes to an existing account belong to its user.
+ALTER TABLE verification_ ADD COLUMN user_id_ uuid REFERENCES user_ ON DELETE CASCADE;
+
+CREATE INDEX verification_user_id_idx ON verification_ (user_id_);
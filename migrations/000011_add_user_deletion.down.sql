@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_deletion_at_idx;
+ALTER TABLE user_ DROP COLUMN IF EXISTS deletion_at_;
@@ -0,0 +1,3 @@
+ALTER TABLE user_ ADD COLUMN deletion_at_ TIMESTAMPTZ;
+
+CREATE INDEX user_deletion_at_idx ON user_ (deletion_at_) WHERE deletion_at_ IS NOT NULL;
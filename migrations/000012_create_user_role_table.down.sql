@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_role_;
+ALTER TABLE user_ DROP COLUMN IF EXISTS disabled_at_;
@@ -0,0 +1,8 @@
+ALTER TABLE user_ ADD COLUMN disabled_at_ TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS user_role_ (
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    role_ TEXT NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id_, role_)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_token_ (
+    id_ uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+    hash_ BYTEA UNIQUE NOT NULL,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    scope_ TEXT NOT NULL,
+    expiry_ TIMESTAMPTZ,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at_ TIMESTAMPTZ
+);
+
+CREATE INDEX api_token_user_id_idx ON api_token_ (user_id_);
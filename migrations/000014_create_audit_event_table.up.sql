@@ -0,0 +1,26 @@
+-- Append-only log of security events. There is no foreign key on the
+-- user, failed logins are recorded for unknown emails too. Events of
+-- deleted users are removed together with the user.
+CREATE TABLE IF NOT EXISTS audit_event_ (
+    id_ BIGSERIAL PRIMARY KEY,
+    user_id_ uuid,
+    email_ TEXT NOT NULL,
+    type_ TEXT NOT NULL,
+    outcome_ TEXT NOT NULL,
+    ip_ TEXT NOT NULL,
+    user_agent_ TEXT NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_event_user_id_idx ON audit_event_ (user_id_, created_at_ DESC);
+CREATE INDEX audit_event_email_idx ON audit_event_ (email_, created_at_ DESC);
+
+CREATE OR REPLACE FUNCTION audit_event_append_only_() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_event_ is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_event_append_only_
+    BEFORE UPDATE ON audit_event_
+    FOR EACH ROW EXECUTE FUNCTION audit_event_append_only_();
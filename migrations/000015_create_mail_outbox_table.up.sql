@@ -0,0 +1,18 @@
+-- Outbound mail waiting to be sent. Rows are deleted once sent, and
+-- kept with failed_at_ set after the last attempt fails. Messages are
+-- plain text and may contain tokens, so failed rows are purged after a
+-- retention period (see purgeFailedMail).
+CREATE TABLE IF NOT EXISTS mail_outbox_ (
+    id_ BIGSERIAL PRIMARY KEY,
+    sender_ TEXT NOT NULL,
+    recipients_ TEXT[] NOT NULL,
+    message_ BYTEA NOT NULL,
+    attempts_ INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error_ TEXT NOT NULL DEFAULT '',
+    failed_at_ TIMESTAMPTZ,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX mail_outbox_pending_idx ON mail_outbox_ (next_attempt_at_)
+    WHERE failed_at_ IS NULL;
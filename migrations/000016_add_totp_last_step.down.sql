@@ -0,0 +1 @@
+ALTER TABLE user_ DROP COLUMN IF EXISTS totp_last_step_;
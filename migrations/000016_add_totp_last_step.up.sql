@@ -0,0 +1,2 @@
+-- Time step of the last accepted TOTP code, so a code can't be replayed
+ALTER TABLE user_ ADD COLUMN totp_last_step_ BIGINT;
@@ -0,0 +1,35 @@
+// Package migrations embeds the SQL migrations applied with golang-migrate.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var Files embed.FS
+
+// Get the version of the latest migration, which is the version the
+// database is expected to be at.
+func Latest() (uint, error) {
+	names, err := fs.Glob(Files, "*.up.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+
+		v, err := strconv.ParseUint(prefix, 10, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		latest = max(latest, uint(v))
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,102 @@
+"use strict";
+
+// Convert between ArrayBuffers and the unpadded base64url strings used
+// by the server.
+function decode(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+}
+
+function encode(buf) {
+    const s = String.fromCharCode(...new Uint8Array(buf));
+    return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+async function post(url, csrf, body) {
+    const res = await fetch(url, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+            "X-CSRF-Token": csrf,
+        },
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!res.ok) {
+        throw new Error(await res.text());
+    }
+    return res.json();
+}
+
+async function registerPasskey(csrf, name) {
+    const { publicKey } = await post("/auth/webauthn/register/begin", csrf);
+    publicKey.challenge = decode(publicKey.challenge);
+    publicKey.user.id = decode(publicKey.user.id);
+    for (const c of publicKey.excludeCredentials || []) {
+        c.id = decode(c.id);
+    }
+
+    const cred = await navigator.credentials.create({ publicKey });
+
+    const url = "/auth/webauthn/register/finish?name=" + encodeURIComponent(name);
+    return post(url, csrf, {
+        id: cred.id,
+        rawId: encode(cred.rawId),
+        type: cred.type,
+        response: {
+            attestationObject: encode(cred.response.attestationObject),
+            clientDataJSON: encode(cred.response.clientDataJSON),
+            transports: cred.response.getTransports ? cred.response.getTransports() : [],
+        },
+    });
+}
+
+async function loginPasskey(csrf) {
+    const { publicKey } = await post("/auth/webauthn/login/begin", csrf);
+    publicKey.challenge = decode(publicKey.challenge);
+    for (const c of publicKey.allowCredentials || []) {
+        c.id = decode(c.id);
+    }
+
+    const cred = await navigator.credentials.get({ publicKey });
+
+    return post("/auth/webauthn/login/finish", csrf, {
+        id: cred.id,
+        rawId: encode(cred.rawId),
+        type: cred.type,
+        response: {
+            authenticatorData: encode(cred.response.authenticatorData),
+            clientDataJSON: encode(cred.response.clientDataJSON),
+            signature: encode(cred.response.signature),
+            userHandle: cred.response.userHandle ? encode(cred.response.userHandle) : null,
+        },
+    });
+}
+
+const registerButton = document.getElementById("passkey-register");
+if (registerButton) {
+    registerButton.addEventListener("click", async () => {
+        const name = document.getElementById("passkey-name").value.trim();
+        if (!name) {
+            alert("Please name your passkey.");
+            return;
+        }
+        try {
+            const res = await registerPasskey(registerButton.dataset.csrf, name);
+            location.assign(res.redirect);
+        } catch (err) {
+            alert(err.message);
+        }
+    });
+}
+
+const loginButton = document.getElementById("passkey-login");
+if (loginButton) {
+    loginButton.addEventListener("click", async () => {
+        try {
+            const res = await loginPasskey(loginButton.dataset.csrf);
+            location.assign(res.redirect);
+        } catch (err) {
+            alert(err.message);
+        }
+    });
+}